@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/Azure/aks-tls-bootstrap/pkg/approver"
+	"github.com/Azure/aks-tls-bootstrap/pkg/kubeclient"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
@@ -14,11 +15,15 @@ func Execute() {
 	var webhookPort int
 	var enableLeaderElection bool
 	var healthAddr string
+	var deleteTokenAfterApproval bool
 
 	flag.StringVar(&metricsAddr, "metrics-addr", "0", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", true, "Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
 	flag.IntVar(&webhookPort, "webhook-port", 0, "Webhook Server port, disabled by default. When enabled, the manager will only work as webhook server, no reconcilers are installed.")
 	flag.StringVar(&healthAddr, "health-addr", ":9440", "The address the health endpoint binds to.")
+	flag.BoolVar(&deleteTokenAfterApproval, "delete-token-after-approval", false, "Delete the bootstrap token used to request a client certificate once the approved certificate has been issued.")
+	kubeclientOptions := kubeclient.Options{}
+	kubeclientOptions.BindFlags(flag.CommandLine)
 
 	flag.Parse()
 
@@ -26,7 +31,7 @@ func Execute() {
 
 	setupLog := ctrl.Log.WithName("setup")
 
-	if err := approver.Run(os.Getenv("POD_NS"), metricsAddr, healthAddr, webhookPort, enableLeaderElection, setupLog); err != nil {
+	if err := approver.Run(os.Getenv("POD_NS"), metricsAddr, healthAddr, webhookPort, enableLeaderElection, deleteTokenAfterApproval, kubeclientOptions, setupLog); err != nil {
 		setupLog.Error(err, "approver exited with error")
 		os.Exit(1)
 	}
@@ -5,16 +5,21 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/Azure/aks-tls-bootstrap/pkg/azure"
 	"github.com/Azure/aks-tls-bootstrap/pkg/client"
+	"github.com/Azure/aks-tls-bootstrap/pkg/redact"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	log       = logrus.New()
-	clientId  = flag.String("client-id", "", "The client ID for the assigned identity to use.")
-	logFormat = flag.String("log-format", "json", "Log format: json or text, default: json")
-	nextProto = flag.String("next-proto", "aks-tls-bootstrap", "ALPN Next Protocol value to send.")
-	debug     = flag.Bool("debug", false, "enable debug logging (WILL LOG AUTHENTICATION DATA)")
+	log                = logrus.New()
+	clientId           = flag.String("client-id", "", "The client ID for the assigned identity to use.")
+	logFormat          = flag.String("log-format", "json", "Log format: json or text, default: json")
+	nextProto          = flag.String("next-proto", "aks-tls-bootstrap", "ALPN Next Protocol value to send.")
+	cloudName          = flag.String("cloud", "", "The Azure cloud to authenticate against. Defaults to the cloud in azure.json, or AzurePublicCloud.")
+	audience           = flag.String("audience", azure.DEFAULT_AUDIENCE, "The audience to request Azure AD tokens for. Must match the audience configured on the server.")
+	debug              = flag.Bool("debug", false, "enable debug logging. Tokens and secrets are redacted unless -debug-unsafe-secrets is set.")
+	debugUnsafeSecrets = flag.Bool("debug-unsafe-secrets", false, "log tokens and secrets in full at debug level (WILL LOG AUTHENTICATION DATA)")
 )
 
 func main() {
@@ -31,8 +36,12 @@ func main() {
 	if *debug {
 		log.SetLevel(logrus.DebugLevel)
 	}
+	if *debugUnsafeSecrets {
+		log.Warn("secret redaction is disabled, logs will contain authentication data")
+		redact.SetUnsafeSecrets(true)
+	}
 
-	token, err := client.GetBootstrapToken(log, *clientId, *nextProto)
+	token, err := client.GetBootstrapToken(log, *clientId, *nextProto, *cloudName, *audience)
 	if err != nil {
 		log.Fatalf("Failed to retrieve bootstrap token: %v", err)
 	}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/Azure/aks-tls-bootstrap/pkg/azure"
+	"github.com/Azure/aks-tls-bootstrap/pkg/config"
+	server "github.com/Azure/aks-tls-bootstrap/pkg/server"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultConfiguration returns the configuration used for any setting not present
+// in the configuration file or on the command line.
+func defaultConfiguration() *config.ServerConfiguration {
+	return &config.ServerConfiguration{
+		APIVersion: config.API_VERSION,
+		Kind:       config.KIND,
+		Listen: config.ListenConfiguration{
+			Hostname:       "0.0.0.0",
+			Port:           9123,
+			HealthAddress:  ":8080",
+			MetricsAddress: ":9090",
+		},
+		TLS: config.TLSConfiguration{
+			ClientAuth: server.CLIENT_AUTH_TOKEN,
+		},
+		Azure: config.AzureConfiguration{
+			ConfigPath:               azure.AZURE_JSON_PATH,
+			JwksRefreshInterval:      metaV1.Duration{Duration: server.JWKS_REFRESH_INTERVAL},
+			Audience:                 azure.DEFAULT_AUDIENCE,
+			AllowedSigningAlgorithms: []string{server.DEFAULT_SIGNING_ALGORITHM},
+			ClockSkew:                metaV1.Duration{Duration: server.DEFAULT_CLOCK_SKEW},
+		},
+		Attestation: config.AttestationConfiguration{
+			SignerHostName: "metadata.azure.com",
+		},
+		Authorization: config.AuthorizationConfiguration{
+			AllowedIdentities: config.AllowedIdentitiesConfiguration{
+				Kind:      server.ALLOWED_IDENTITIES_KIND_CONFIGMAP,
+				Namespace: "kube-system",
+			},
+		},
+		Admission: config.AdmissionConfiguration{
+			NodePoolTag: server.DEFAULT_NODE_POOL_TAG,
+		},
+		Nonce: config.NonceConfiguration{
+			Store:                   server.NONCE_STORE_MEMORY,
+			Namespace:               "kube-system",
+			Lifetime:                metaV1.Duration{Duration: server.NONCE_LIFETIME},
+			ExpirationCheckInterval: metaV1.Duration{Duration: server.NONCE_EXPIRATION_CHECK_INTERVAL},
+		},
+		Token: config.TokenConfiguration{
+			Lifetime:   metaV1.Duration{Duration: server.TOKEN_LIFETIME},
+			GCInterval: metaV1.Duration{Duration: server.TOKEN_GC_INTERVAL},
+		},
+		Logging: config.LoggingConfiguration{
+			Format: "json",
+		},
+	}
+}
+
+// validateConfiguration returns every problem found by config.Validate, together
+// with the values only the server knows how to check.
+func validateConfiguration(cfg *config.ServerConfiguration) []error {
+	errs := cfg.Validate()
+	addError := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	switch cfg.TLS.ClientAuth {
+	case server.CLIENT_AUTH_TOKEN:
+	case server.CLIENT_AUTH_CERTIFICATE, server.CLIENT_AUTH_CERTIFICATE_AND_TOKEN:
+		if cfg.TLS.CertFile == "" {
+			addError("tls.certFile is required with tls.clientAuth %s", cfg.TLS.ClientAuth)
+		}
+		if cfg.TLS.ClientCAFile == "" {
+			addError("tls.clientCAFile is required with tls.clientAuth %s", cfg.TLS.ClientAuth)
+		}
+		if len(cfg.Authorization.AllowedCertificateIdentities) == 0 {
+			addError("authorization.allowedCertificateIdentities is required with tls.clientAuth %s", cfg.TLS.ClientAuth)
+		}
+	default:
+		addError("tls.clientAuth must be %s, %s or %s, got %q", server.CLIENT_AUTH_TOKEN, server.CLIENT_AUTH_CERTIFICATE, server.CLIENT_AUTH_CERTIFICATE_AND_TOKEN, cfg.TLS.ClientAuth)
+	}
+
+	switch cfg.Authorization.AllowedIdentities.Kind {
+	case server.ALLOWED_IDENTITIES_KIND_CONFIGMAP, server.ALLOWED_IDENTITIES_KIND_SECRET:
+	default:
+		addError("authorization.allowedIdentities.kind must be %s or %s, got %q", server.ALLOWED_IDENTITIES_KIND_CONFIGMAP, server.ALLOWED_IDENTITIES_KIND_SECRET, cfg.Authorization.AllowedIdentities.Kind)
+	}
+
+	for i, rule := range cfg.Admission.RequiredTags {
+		_, err := server.ParseTagRule(rule)
+		if err != nil {
+			addError("admission.requiredTags[%d]: %v", i, err)
+		}
+	}
+
+	switch cfg.Nonce.Store {
+	case server.NONCE_STORE_MEMORY:
+	case server.NONCE_STORE_KUBERNETES:
+		if cfg.Nonce.Namespace == "" {
+			addError("nonce.namespace is required with nonce.store %s", server.NONCE_STORE_KUBERNETES)
+		}
+	default:
+		addError("nonce.store must be %s or %s, got %q", server.NONCE_STORE_MEMORY, server.NONCE_STORE_KUBERNETES, cfg.Nonce.Store)
+	}
+
+	return errs
+}
+
+// applyFlags overrides the configuration with every flag set on the command line.
+// Flags left at their defaults do not override the configuration file.
+func applyFlags(cfg *config.ServerConfiguration, requiredTags tagRuleList) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "log-format":
+			cfg.Logging.Format = *logFormat
+		case "debug":
+			cfg.Logging.Debug = *debug
+		case "debug-unsafe-secrets":
+			cfg.Logging.DebugUnsafeSecrets = *debugUnsafeSecrets
+		case "overlay-kubeconfig-source":
+			cfg.Kubernetes.Source = kubeclientOptions.Source
+		case "overlay-kubeconfig":
+			cfg.Kubernetes.KubeconfigPath = kubeclientOptions.KubeconfigPath
+		case "overlay-kubeconfig-secret-namespace":
+			cfg.Kubernetes.SecretNamespace = kubeclientOptions.SecretNamespace
+		case "overlay-kubeconfig-secret-name":
+			cfg.Kubernetes.SecretName = kubeclientOptions.SecretName
+		case "overlay-kubeconfig-secret-key":
+			cfg.Kubernetes.SecretKey = kubeclientOptions.SecretKey
+		case "hostname":
+			cfg.Listen.Hostname = *hostname
+		case "port":
+			cfg.Listen.Port = *port
+		case "health-addr":
+			cfg.Listen.HealthAddress = *healthAddr
+		case "metrics-addr":
+			cfg.Listen.MetricsAddress = *metricsAddr
+		case "enable-reflection":
+			cfg.Listen.EnableReflection = *enableReflection
+		case "tls-cert":
+			cfg.TLS.CertFile = *tlsCert
+		case "tls-key":
+			cfg.TLS.KeyFile = *tlsKey
+		case "client-ca-file":
+			cfg.TLS.ClientCAFile = *clientCAFile
+		case "client-auth":
+			cfg.TLS.ClientAuth = *clientAuthMode
+		case "azure-config":
+			cfg.Azure.ConfigPath = *azureConfigPath
+		case "cloud":
+			cfg.Azure.Cloud = *cloudName
+		case "resource-manager-endpoint":
+			cfg.Azure.ResourceManagerEndpoint = *resourceManagerUrl
+		case "tenant-id":
+			cfg.Azure.TenantId = *tenantId
+		case "jwks-url":
+			cfg.Azure.JwksUrl = *jwksUrl
+		case "token-issuer-host":
+			cfg.Azure.TokenIssuerHost = *tokenIssuerHost
+		case "jwks-refresh-interval":
+			cfg.Azure.JwksRefreshInterval = metaV1.Duration{Duration: *jwksRefreshInterval}
+		case "audience":
+			cfg.Azure.Audience = *audience
+		case "allowed-signing-algorithms":
+			cfg.Azure.AllowedSigningAlgorithms = splitList(*signingAlgorithms)
+		case "clock-skew":
+			cfg.Azure.ClockSkew = metaV1.Duration{Duration: *clockSkew}
+		case "imds-signer-name":
+			cfg.Attestation.SignerHostName = *signerHostName
+		case "root-cert-dir":
+			cfg.Attestation.RootCertDir = *rootCertDir
+		case "intermediate-cert-dir":
+			cfg.Attestation.IntermediateCertDir = *intermediateCertDir
+		case "allowed-client-ids":
+			cfg.Authorization.AllowedClientIds = splitList(*allowedClientIds)
+		case "allowed-certificate-identities":
+			cfg.Authorization.AllowedCertificateIdentities = splitList(*allowedCertIds)
+		case "allowed-identities-kind":
+			cfg.Authorization.AllowedIdentities.Kind = *allowedIdsKind
+		case "allowed-identities-namespace":
+			cfg.Authorization.AllowedIdentities.Namespace = *allowedIdsNamespace
+		case "allowed-identities-name":
+			cfg.Authorization.AllowedIdentities.Name = *allowedIdsName
+		case "allowed-app-ids":
+			cfg.Authorization.AllowedAppIds = splitList(*allowedAppIds)
+		case "allowed-app-roles":
+			cfg.Authorization.AllowedAppRoles = splitList(*allowedAppRoles)
+		case "allowed-group-ids":
+			cfg.Authorization.AllowedGroupIds = splitList(*allowedGroupIds)
+		case "reject-group-overage":
+			cfg.Authorization.RejectGroupOverage = *rejectGroupOverage
+		case "allowed-subscription-ids":
+			cfg.Admission.AllowedSubscriptionIds = splitList(*allowedSubIds)
+		case "allowed-resource-groups":
+			cfg.Admission.AllowedResourceGroups = splitList(*allowedRgs)
+		case "allowed-vmss-names":
+			cfg.Admission.AllowedVmssNames = splitList(*allowedVmssNames)
+		case "required-tag":
+			cfg.Admission.RequiredTags = requiredTags
+		case "node-pool-tag":
+			cfg.Admission.NodePoolTag = *nodePoolTag
+		case "nonce-store":
+			cfg.Nonce.Store = *nonceStore
+		case "nonce-store-namespace":
+			cfg.Nonce.Namespace = *nonceStoreNamespace
+		case "nonce-lifetime":
+			cfg.Nonce.Lifetime = metaV1.Duration{Duration: *nonceLifetime}
+		case "nonce-expiration-check-interval":
+			cfg.Nonce.ExpirationCheckInterval = metaV1.Duration{Duration: *nonceCheckInterval}
+		case "token-lifetime":
+			cfg.Token.Lifetime = metaV1.Duration{Duration: *tokenLifetime}
+		case "token-gc-interval":
+			cfg.Token.GCInterval = metaV1.Duration{Duration: *tokenGCInterval}
+		case "audit-log":
+			cfg.Audit.LogPath = *auditLog
+		case "audit-events":
+			cfg.Audit.KubernetesEvents = *auditEvents
+		}
+	})
+}
+
+// resolveAzure determines the tenant and cloud, filling in anything not configured
+// from azure.json. azure.json may be missing if the tenant is configured. The cloud
+// is only resolved if resolveCloud is set, as custom clouds require a request to ARM.
+func resolveAzure(cfg *config.ServerConfiguration, resolveCloud bool) (string, *azure.Environment, error) {
+	tenant := cfg.Azure.TenantId
+	cloudName := cfg.Azure.Cloud
+	resourceManagerEndpoint := cfg.Azure.ResourceManagerEndpoint
+
+	if cfg.Azure.ConfigPath != "" {
+		azureConfig, err := azure.LoadAzureJson(cfg.Azure.ConfigPath)
+		if err != nil {
+			if tenant == "" {
+				return "", nil, fmt.Errorf("azure.tenantId is not configured and azure.json could not be loaded: %v", err)
+			}
+			log.WithError(err).Warn("failed to load azure.json, using configured tenant and cloud")
+		} else {
+			if tenant == "" {
+				tenant = azureConfig.TenantId
+			}
+			if cloudName == "" {
+				cloudName = azureConfig.Cloud
+			}
+			if resourceManagerEndpoint == "" {
+				resourceManagerEndpoint = azureConfig.ResourceManagerEndpoint
+			}
+		}
+	}
+	if tenant == "" {
+		return "", nil, fmt.Errorf("azure.tenantId is not configured and azure.json does not contain a tenant ID")
+	}
+
+	if !resolveCloud {
+		return tenant, nil, nil
+	}
+	cloud, err := azure.GetEnvironment(cloudName, resourceManagerEndpoint)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to determine Azure cloud: %v", err)
+	}
+	if cfg.Azure.TokenIssuerHost != "" {
+		cloud = cloud.WithTokenIssuerHost(cfg.Azure.TokenIssuerHost)
+	} else if cloud.TokenIssuerHost == "" {
+		discovered, err := cloud.DiscoverTokenIssuerHost()
+		if err != nil {
+			log.WithError(err).WithField("cloud", cloud.Name).Warn("failed to discover the v1.0 token issuer, only v2.0 tokens are accepted; set azure.tokenIssuerHost to accept v1.0 tokens")
+		} else {
+			cloud = discovered
+		}
+	}
+
+	return tenant, cloud, nil
+}
+
+// newServer builds the server from a validated configuration.
+func newServer(cfg *config.ServerConfiguration, tenant string, cloud *azure.Environment) *server.TlsBootstrapServer {
+	requiredTags := []*server.TagRule{}
+	for _, value := range cfg.Admission.RequiredTags {
+		// already checked by validateConfiguration
+		rule, _ := server.ParseTagRule(value)
+		requiredTags = append(requiredTags, rule)
+	}
+
+	return &server.TlsBootstrapServer{
+		KubeClient:                   cfg.Kubernetes,
+		AllowedClientIds:             cfg.Authorization.AllowedClientIds,
+		AllowedCertificateIdentities: cfg.Authorization.AllowedCertificateIdentities,
+		AllowedIdentitiesKind:        cfg.Authorization.AllowedIdentities.Kind,
+		AllowedIdentitiesNamespace:   cfg.Authorization.AllowedIdentities.Namespace,
+		AllowedIdentitiesName:        cfg.Authorization.AllowedIdentities.Name,
+		IntermediateCertPath:         cfg.Attestation.IntermediateCertDir,
+		JwksUrl:                      cfg.Azure.JwksUrl,
+		CallerPolicy: server.CallerPolicy{
+			AllowedAppIds:      cfg.Authorization.AllowedAppIds,
+			AllowedRoles:       cfg.Authorization.AllowedAppRoles,
+			AllowedGroupIds:    cfg.Authorization.AllowedGroupIds,
+			RejectGroupOverage: cfg.Authorization.RejectGroupOverage,
+		},
+		AdmissionPolicy: server.AdmissionPolicy{
+			AllowedSubscriptionIds: cfg.Admission.AllowedSubscriptionIds,
+			AllowedResourceGroups:  cfg.Admission.AllowedResourceGroups,
+			AllowedVmssNames:       cfg.Admission.AllowedVmssNames,
+			RequiredTags:           requiredTags,
+			NodePoolTag:            cfg.Admission.NodePoolTag,
+		},
+		AuditKubernetesEvents:        cfg.Audit.KubernetesEvents,
+		ClientAuthMode:               cfg.TLS.ClientAuth,
+		NonceStoreBackend:            cfg.Nonce.Store,
+		NonceStoreNamespace:          cfg.Nonce.Namespace,
+		RootCertPath:                 cfg.Attestation.RootCertDir,
+		SignerHostName:               cfg.Attestation.SignerHostName,
+		TenantId:                     tenant,
+		Cloud:                        cloud,
+		AzureConfigPath:              cfg.Azure.ConfigPath,
+		NonceLifetime:                cfg.Nonce.Lifetime.Duration,
+		TokenLifetime:                cfg.Token.Lifetime.Duration,
+		NonceExpirationCheckInterval: cfg.Nonce.ExpirationCheckInterval.Duration,
+		TokenGCInterval:              cfg.Token.GCInterval.Duration,
+		JwksRefreshInterval:          cfg.Azure.JwksRefreshInterval.Duration,
+		Audience:                     cfg.Azure.Audience,
+		ClockSkew:                    cfg.Azure.ClockSkew.Duration,
+		AllowedSigningAlgorithms:     cfg.Azure.AllowedSigningAlgorithms,
+	}
+}
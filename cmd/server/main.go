@@ -1,97 +1,190 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"strings"
 
-	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"github.com/Azure/aks-tls-bootstrap/pkg/azure"
+	"github.com/Azure/aks-tls-bootstrap/pkg/config"
+	"github.com/Azure/aks-tls-bootstrap/pkg/kubeclient"
 	pb "github.com/Azure/aks-tls-bootstrap/pkg/proto"
+	"github.com/Azure/aks-tls-bootstrap/pkg/redact"
 	server "github.com/Azure/aks-tls-bootstrap/pkg/server"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 var (
 	log                 = logrus.New()
+	configPath          = flag.String("config", "", "Path to a "+config.KIND+" file in YAML or JSON. Flags set on the command line override values in the file.")
+	validateConfig      = flag.Bool("validate-config", false, "Validate the configuration, print every error found and exit.")
+	kubeclientOptions   = kubeclient.Options{}
+	tenantId            = flag.String("tenant-id", "", "The Azure AD tenant callers must belong to. Defaults to the tenant in azure.json.")
 	logFormat           = flag.String("log-format", "json", "Log format: json or text, default: json")
 	hostname            = flag.String("hostname", "0.0.0.0", "The hostname to listen on.")
 	port                = flag.Int("port", 9123, "The port to run the gRPC server on.")
-	jwksUrl             = flag.String("jwks-url", "https://login.microsoftonline.com/common/discovery/v2.0/keys", "The JWKS endpoint for the Azure AD to use.")
+	jwksUrl             = flag.String("jwks-url", "", "The JWKS endpoint for the Azure AD to use. Defaults to the endpoint of the selected cloud.")
+	tokenIssuerHost     = flag.String("token-issuer-host", "", "The issuer host of Azure AD v1.0 access tokens, e.g. https://sts.windows.net/. Defaults to the host of the selected cloud, discovered for custom clouds.")
+	azureConfigPath     = flag.String("azure-config", azure.AZURE_JSON_PATH, "Path to the azure.json file holding the credential used to query ARM. The credential is reloaded when the file changes.")
+	cloudName           = flag.String("cloud", "", "The Azure cloud to use: AzurePublicCloud, AzureChinaCloud, AzureUSGovernmentCloud or a custom cloud name. Defaults to the cloud in azure.json.")
+	resourceManagerUrl  = flag.String("resource-manager-endpoint", "", "The ARM endpoint used to discover a custom cloud. Defaults to the resourceManagerEndpoint in azure.json.")
 	signerHostName      = flag.String("imds-signer-name", "metadata.azure.com", "The hostname that must be present in the signing certificate from IMDS.")
 	allowedClientIds    = flag.String("allowed-client-ids", "", "A comma separated list of allowed client IDs for the service.")
+	allowedIdsKind      = flag.String("allowed-identities-kind", server.ALLOWED_IDENTITIES_KIND_CONFIGMAP, "The kind of object holding allowed identities: ConfigMap or Secret.")
+	allowedIdsNamespace = flag.String("allowed-identities-namespace", "kube-system", "The namespace in the overlay cluster of the object holding allowed identities.")
+	allowedIdsName      = flag.String("allowed-identities-name", "", "The name of a ConfigMap or Secret in the overlay cluster whose identities.yaml key lists allowed identities. Changes are applied without restart.")
+	allowedAppIds       = flag.String("allowed-app-ids", "", "A comma separated list of Azure AD application IDs (appid/azp claim) allowed to request tokens.")
+	allowedAppRoles     = flag.String("allowed-app-roles", "", "A comma separated list of app roles (roles claim) allowed to request tokens.")
+	allowedGroupIds     = flag.String("allowed-group-ids", "", "A comma separated list of Azure AD group object IDs (groups claim) whose members are allowed to request tokens.")
+	rejectGroupOverage  = flag.Bool("reject-group-overage", false, "Reject callers whose token omits groups due to overage when -allowed-group-ids is set, instead of skipping group rules for them.")
+	allowedSubIds       = flag.String("allowed-subscription-ids", "", "A comma separated list of subscription IDs joining VMs must be in. If empty, any subscription is allowed.")
+	allowedRgs          = flag.String("allowed-resource-groups", "", "A comma separated list of resource groups joining VMs must be in. If empty, any resource group is allowed.")
+	allowedVmssNames    = flag.String("allowed-vmss-names", "", "A comma separated list of scale set names joining VMs must be part of. If empty, any VM is allowed.")
+	nodePoolTag         = flag.String("node-pool-tag", server.DEFAULT_NODE_POOL_TAG, "The ARM tag holding the node pool name, recorded on issued bootstrap tokens.")
 	tlsCert             = flag.String("tls-cert", "", "TLS certificate path")
+	clientCAFile        = flag.String("client-ca-file", "", "Path to a PEM bundle of CAs which issue client certificates. Required by the certificate client auth modes.")
+	clientAuthMode      = flag.String("client-auth", server.CLIENT_AUTH_TOKEN, "How callers authenticate: token (Azure AD bearer token), certificate (client certificate identity replaces the token) or certificate-and-token (both are required).")
+	allowedCertIds      = flag.String("allowed-certificate-identities", "", "A comma separated list of client certificate identities (URI SAN, DNS SAN or common name) allowed to call the service. Required by the certificate client auth modes.")
 	tlsKey              = flag.String("tls-key", "", "TLS key path")
 	rootCertDir         = flag.String("root-cert-dir", "", "A path to a directory containing root certificates. If not supplied, the system root certificate store will be used.")
 	intermediateCertDir = flag.String("intermediate-cert-dir", "", "A path to a directory containing intermediate certificates to be loaded to the cache.")
-	debug               = flag.Bool("debug", false, "enable debug logging (WILL LOG AUTHENTICATION DATA)")
+	nonceStore          = flag.String("nonce-store", server.NONCE_STORE_MEMORY, "Where to store outstanding nonces: memory or kubernetes. Use kubernetes when running more than one replica.")
+	nonceStoreNamespace = flag.String("nonce-store-namespace", "kube-system", "The namespace in the overlay cluster to store nonces in when -nonce-store is kubernetes.")
+	nonceLifetime       = flag.Duration("nonce-lifetime", server.NONCE_LIFETIME, "How long a nonce remains valid after it is issued.")
+	tokenLifetime       = flag.Duration("token-lifetime", server.TOKEN_LIFETIME, "How long an issued bootstrap token remains valid. Must not be shorter than -nonce-lifetime.")
+	tokenGCInterval     = flag.Duration("token-gc-interval", server.TOKEN_GC_INTERVAL, "How often expired bootstrap tokens issued by the server are deleted from the overlay cluster.")
+	nonceCheckInterval  = flag.Duration("nonce-expiration-check-interval", server.NONCE_EXPIRATION_CHECK_INTERVAL, "How often expired nonces are removed from the nonce store.")
+	jwksRefreshInterval = flag.Duration("jwks-refresh-interval", server.JWKS_REFRESH_INTERVAL, "How often the Azure AD JWKS keys are refreshed.")
+	audience            = flag.String("audience", azure.DEFAULT_AUDIENCE, "The audience Azure AD tokens presented by clients must be issued for. Clients must be configured with the same audience.")
+	clockSkew           = flag.Duration("clock-skew", server.DEFAULT_CLOCK_SKEW, "The clock skew tolerated when validating the exp and nbf claims of Azure AD tokens.")
+	signingAlgorithms   = flag.String("allowed-signing-algorithms", server.DEFAULT_SIGNING_ALGORITHM, "A comma separated list of JWT signing algorithms accepted for Azure AD tokens.")
+	auditLog            = flag.String("audit-log", "", "Path of a file to append a JSON line audit record of every nonce and token request decision to, or - for stdout. Empty disables the audit log.")
+	auditEvents         = flag.Bool("audit-events", false, "Record every token request decision as a Kubernetes Event in the overlay cluster.")
+	metricsAddr         = flag.String("metrics-addr", ":9090", "The address to serve Prometheus /metrics on. Empty disables metrics.")
+	healthAddr          = flag.String("health-addr", ":8080", "The address to serve HTTP /healthz and /readyz probes on. Empty disables the probes.")
+	enableReflection    = flag.Bool("enable-reflection", false, "Register the gRPC reflection service.")
+	debug               = flag.Bool("debug", false, "enable debug logging. Tokens and secrets are redacted unless -debug-unsafe-secrets is set.")
+	debugUnsafeSecrets  = flag.Bool("debug-unsafe-secrets", false, "log tokens and secrets in full at debug level (WILL LOG AUTHENTICATION DATA)")
 )
 
 func main() {
+	kubeclientOptions.BindFlags(flag.CommandLine)
+	requiredTags := tagRuleList{}
+	flag.Var(&requiredTags, "required-tag", "An ARM tag joining VMs must carry, in the form <tag>=<exact|prefix|regex>:<value>. Regular expressions must match the whole value. May be repeated.")
 	flag.Parse()
 	log.SetReportCaller(true)
 	log.SetOutput(os.Stdout)
 
-	switch strings.ToLower(*logFormat) {
+	cfg := defaultConfiguration()
+	if *configPath != "" {
+		err := config.Load(*configPath, cfg)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	applyFlags(cfg, requiredTags)
+
+	errs := validateConfiguration(cfg)
+	if *validateConfig {
+		_, _, err := resolveAzure(cfg, false)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("configuration is valid")
+		return
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		log.Fatalf("configuration is not valid")
+	}
+
+	switch strings.ToLower(cfg.Logging.Format) {
 	case "text":
 		log.SetFormatter(&logrus.TextFormatter{})
 	default:
 		log.SetFormatter(&logrus.JSONFormatter{})
 	}
 
-	if *debug {
+	if cfg.Logging.Debug {
 		log.SetLevel(logrus.DebugLevel)
 	}
+	if cfg.Logging.DebugUnsafeSecrets {
+		log.Warn("secret redaction is disabled, logs will contain authentication data")
+		redact.SetUnsafeSecrets(true)
+	}
 
 	var tlsCreds grpc.ServerOption = nil
-	if *tlsCert != "" {
+	if cfg.TLS.CertFile != "" {
 		log.WithFields(logrus.Fields{
-			"tls-cert": *tlsCert,
-			"tls-key":  *tlsKey,
+			"tls-cert": cfg.TLS.CertFile,
+			"tls-key":  cfg.TLS.KeyFile,
 		}).Infof("fetching TLS certificate")
-		tls, err := credentials.NewServerTLSFromFile(*tlsCert, *tlsKey)
+		reloader, err := server.NewCertificateReloader(logrus.NewEntry(log), cfg.TLS.CertFile, cfg.TLS.KeyFile)
 		if err != nil {
 			log.Fatalf("failed to initialize TLS certificate: %v", err)
 		}
 
-		tlsCreds = grpc.Creds(tls)
+		tlsConfig := &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+		}
+		if server.RequiresClientCertificate(cfg.TLS.ClientAuth) {
+			clientCAs, err := loadCertPool(cfg.TLS.ClientCAFile)
+			if err != nil {
+				log.Fatalf("failed to load client CA bundle: %v", err)
+			}
+			tlsConfig.ClientCAs = clientCAs
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+
+		tlsCreds = grpc.Creds(credentials.NewTLS(tlsConfig))
 	}
 
-	azureConfig := &server.KubeletAzureJson{}
-	azureJson, err := os.ReadFile("/etc/kubernetes/azure.json")
+	tenant, cloud, err := resolveAzure(cfg, true)
 	if err != nil {
-		log.Fatalf("failed to parse /etc/kubernetes/azure.json: %v", err)
+		log.Fatalf("%v", err)
 	}
 
-	if err := json.Unmarshal(azureJson, azureConfig); err != nil {
-		log.Fatalf("failed to unmarshal /etc/kubernetes/azure.json: %v", err)
+	var auditSink server.AuditSink
+	if cfg.Audit.LogPath != "" {
+		auditSink, err = server.NewFileAuditSink(cfg.Audit.LogPath)
+		if err != nil {
+			log.Fatalf("failed to initialize audit log: %v", err)
+		}
 	}
 
-	s := &server.TlsBootstrapServer{
-		Log:                  logrus.NewEntry(log),
-		AllowedClientIds:     strings.Split(*allowedClientIds, ","),
-		IntermediateCertPath: *intermediateCertDir,
-		JwksUrl:              *jwksUrl,
-		RootCertPath:         *rootCertDir,
-		SignerHostName:       *signerHostName,
-		TenantId:             azureConfig.TenantId,
-	}
+	s := newServer(cfg, tenant, cloud)
+	s.Log = logrus.NewEntry(log)
+	s.AuditSink = auditSink
 
 	var grpcServer *grpc.Server
 	if tlsCreds != nil {
 		grpcServer = grpc.NewServer(
 			tlsCreds,
-			grpc.StreamInterceptor(grpc_auth.StreamServerInterceptor(s.ValidateToken)),
-			grpc.UnaryInterceptor(grpc_auth.UnaryServerInterceptor(s.ValidateToken)),
+			grpc.StreamInterceptor(s.StreamAuthInterceptor()),
+			grpc.ChainUnaryInterceptor(s.UnaryAuditInterceptor(), s.UnaryAuthInterceptor()),
 		)
 	} else {
 		grpcServer = grpc.NewServer(
-			grpc.StreamInterceptor(grpc_auth.StreamServerInterceptor(s.ValidateToken)),
-			grpc.UnaryInterceptor(grpc_auth.UnaryServerInterceptor(s.ValidateToken)),
+			grpc.StreamInterceptor(s.StreamAuthInterceptor()),
+			grpc.ChainUnaryInterceptor(s.UnaryAuditInterceptor(), s.UnaryAuthInterceptor()),
 		)
 	}
 
@@ -102,11 +195,83 @@ func main() {
 
 	pb.RegisterAKSBootstrapTokenRequestServer(grpcServer, tlsBootstrapServer)
 
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", *hostname, *port))
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go tlsBootstrapServer.WatchHealth(healthServer)
+
+	if cfg.Listen.EnableReflection {
+		reflection.Register(grpcServer)
+	}
+
+	if cfg.Listen.HealthAddress != "" {
+		go func() {
+			log.Infof("serving health probes on %s", cfg.Listen.HealthAddress)
+			err := http.ListenAndServe(cfg.Listen.HealthAddress, tlsBootstrapServer.HealthHandler())
+			if err != nil {
+				log.Fatalf("failed to serve health probes on %s: %v", cfg.Listen.HealthAddress, err)
+			}
+		}()
+	}
+
+	if cfg.Listen.MetricsAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", server.MetricsHandler())
+			log.Infof("serving metrics on %s", cfg.Listen.MetricsAddress)
+			err := http.ListenAndServe(cfg.Listen.MetricsAddress, mux)
+			if err != nil {
+				log.Fatalf("failed to serve metrics on %s: %v", cfg.Listen.MetricsAddress, err)
+			}
+		}()
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.Listen.Hostname, cfg.Listen.Port))
 	if err != nil {
-		log.Fatalf("failed to listen on %s:%d: %v", *hostname, *port, err)
+		log.Fatalf("failed to listen on %s:%d: %v", cfg.Listen.Hostname, cfg.Listen.Port, err)
 	}
 
-	log.Infof("starting server on %s:%d", *hostname, *port)
+	log.Infof("starting server on %s:%d", cfg.Listen.Hostname, cfg.Listen.Port)
 	grpcServer.Serve(listener)
 }
+
+// loadCertPool reads a PEM bundle of certificates into a pool.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// tagRuleList is a repeatable flag of ARM tag rules.
+type tagRuleList []string
+
+func (t *tagRuleList) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *tagRuleList) Set(value string) error {
+	_, err := server.ParseTagRule(value)
+	if err != nil {
+		return err
+	}
+	*t = append(*t, value)
+	return nil
+}
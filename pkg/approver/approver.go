@@ -6,53 +6,33 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"os"
 	"reflect"
 	"strings"
 
+	"github.com/Azure/aks-tls-bootstrap/pkg/kubeclient"
 	"github.com/go-logr/logr"
 	certv1 "k8s.io/api/certificates/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-func Run(podNs, metricsAddr, healthAddr string, webhookPort int, enableLeaderElection bool, setupLog logr.Logger) error {
+func Run(podNs, metricsAddr, healthAddr string, webhookPort int, enableLeaderElection bool, deleteTokenAfterApproval bool, kubeclientOptions kubeclient.Options, setupLog logr.Logger) error {
 	scheme := runtime.NewScheme()
 	_ = clientgoscheme.AddToScheme(scheme)
 
-	kubeconfig, err := config.GetConfig()
+	cfg, err := kubeclient.GetConfig(kubeclientOptions)
 	if err != nil {
-		return fmt.Errorf("failed to initialize kubernetes client: %v", err)
-	}
-
-	clientset, err := kubernetes.NewForConfig(kubeconfig)
-	if err != nil {
-		return fmt.Errorf("failed to create clientset: %v", err)
-	}
-
-	kcSecret, err := clientset.CoreV1().Secrets(os.Getenv("POD_NS")).Get(context.Background(), "kubeconfig-file", metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("getting kubeconfig secret: %s", err)
-	}
-
-	if kcSecret.Data == nil {
-		return fmt.Errorf("kubeconfig secret is empty")
-	}
-
-	cfg, err := clientcmd.RESTConfigFromKubeConfig(kcSecret.Data["kubeconfig.yaml"])
-	if err != nil {
-		return fmt.Errorf("parsing overlay kubeconfig: %s", err)
+		return err
 	}
 
 	overlay, err := kubernetes.NewForConfig(cfg)
@@ -86,7 +66,7 @@ func Run(podNs, metricsAddr, healthAddr string, webhookPort int, enableLeaderEle
 	err = builder.
 		ControllerManagedBy(mgr).
 		For(&certv1.CertificateSigningRequest{}).
-		Complete(&csrReconciler{Kubeclient: overlay, Log: ctrl.Log.WithName("csrcontroller")})
+		Complete(&csrReconciler{Kubeclient: overlay, DeleteTokenAfterApproval: deleteTokenAfterApproval, Log: ctrl.Log.WithName("csrcontroller")})
 	if err != nil {
 		return fmt.Errorf("could not create controller: %s", err)
 	}
@@ -96,10 +76,20 @@ func Run(podNs, metricsAddr, healthAddr string, webhookPort int, enableLeaderEle
 	return mgr.Start(ctrl.SetupSignalHandler())
 }
 
+// bootstrap tokens created by the TLS bootstrap server carry this label, see
+// BOOTSTRAP_TOKEN_ISSUER_LABEL in pkg/server.
+const (
+	bootstrapTokenIssuerLabel = "kubernetes.azure.com/tls-bootstrap-issuer"
+	bootstrapTokenIssuer      = "aks-tls-bootstrap-server"
+)
+
 type csrReconciler struct {
 	client.Client
 	Kubeclient kubernetes.Interface
-	Log        logr.Logger
+	// DeleteTokenAfterApproval deletes the bootstrap token used to request a client
+	// certificate once the certificate has been issued, so it cannot be reused.
+	DeleteTokenAfterApproval bool
+	Log                      logr.Logger
 }
 
 func (r *csrReconciler) InjectClient(c client.Client) error {
@@ -114,6 +104,17 @@ func (r *csrReconciler) Reconcile(ctx context.Context, req reconcile.Request) (r
 		return reconcile.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// the token must stay valid until the certificate is issued, as kubelet uses it
+	// to poll for the result; the CSR update which sets the certificate is reconciled
+	// here even though the CSR is otherwise skipped.
+	if r.DeleteTokenAfterApproval && isIssuedBootstrapClientCert(&obj) {
+		if err := r.deleteBootstrapToken(ctx, &obj); err != nil {
+			// the token still expires on its own, so do not requeue an issued csr
+			r.Log.Error(err, "failed to delete bootstrap token after issuance")
+		}
+		return reconcile.Result{}, nil
+	}
+
 	if shouldSkip(&obj) {
 		r.Log.Info("skipping csr")
 		return reconcile.Result{}, nil
@@ -225,6 +226,39 @@ func (r *csrReconciler) handleClientCert(ctx context.Context, csr *certv1.Certif
 	return nil
 }
 
+// deleteBootstrapToken deletes the bootstrap token which requested the csr, if it was
+// issued by the TLS bootstrap server.
+func (r *csrReconciler) deleteBootstrapToken(ctx context.Context, csr *certv1.CertificateSigningRequest) error {
+	tokenId, err := usernameToToken(csr.Spec.Username)
+	if err != nil {
+		return err
+	}
+
+	secrets := r.Kubeclient.CoreV1().Secrets(metav1.NamespaceSystem)
+	secret, err := secrets.Get(ctx, "bootstrap-token-"+tokenId, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get bootstrap token %s: %w", tokenId, err)
+	}
+
+	if secret.Labels[bootstrapTokenIssuerLabel] != bootstrapTokenIssuer {
+		r.Log.Info("not deleting bootstrap token which was not issued by the tls bootstrap server", "tokenId", tokenId)
+		return nil
+	}
+
+	err = secrets.Delete(ctx, secret.Name, metav1.DeleteOptions{
+		Preconditions: metav1.NewUIDPreconditions(string(secret.UID)),
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete bootstrap token %s: %w", tokenId, err)
+	}
+
+	r.Log.Info("deleted bootstrap token after certificate issuance", "tokenId", tokenId)
+	return nil
+}
+
 func usernameToToken(username string) (string, error) {
 	if !strings.HasPrefix(username, "system:bootstrap:") {
 		return "", fmt.Errorf("client csr should be requested by system:bootstrap:<token_id>, not %s", username)
@@ -241,6 +275,18 @@ func usernameToToken(username string) (string, error) {
 	return tokenId, nil
 }
 
+// isIssuedBootstrapClientCert returns true if the csr is a client certificate request
+// made with a bootstrap token whose certificate has been issued.
+func isIssuedBootstrapClientCert(csr *certv1.CertificateSigningRequest) bool {
+	if csr.Spec.SignerName != certv1.KubeAPIServerClientSignerName || len(csr.Status.Certificate) == 0 {
+		return false
+	}
+	if approved, denied := getCertApprovalCondition(&csr.Status); !approved || denied {
+		return false
+	}
+	return strings.HasPrefix(csr.Spec.Username, "system:bootstrap:")
+}
+
 func shouldSkip(csr *certv1.CertificateSigningRequest) bool {
 	if len(csr.Status.Certificate) != 0 {
 		return true
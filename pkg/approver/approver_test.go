@@ -0,0 +1,129 @@
+package approver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	certv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const testTokenId = "abcdef"
+
+func newTestCsr(username string, approved bool, certificate []byte) *certv1.CertificateSigningRequest {
+	csr := &certv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "csr-test"},
+		Spec: certv1.CertificateSigningRequestSpec{
+			SignerName: certv1.KubeAPIServerClientSignerName,
+			Username:   username,
+		},
+		Status: certv1.CertificateSigningRequestStatus{
+			Certificate: certificate,
+		},
+	}
+	if approved {
+		appendApprovalCondition(csr, "test")
+	}
+	return csr
+}
+
+func newTestBootstrapTokenSecret(labels map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap-token-" + testTokenId,
+			Namespace: metav1.NamespaceSystem,
+			Labels:    labels,
+		},
+	}
+}
+
+func TestReconcileDeletesTokenOnlyAfterIssuance(t *testing.T) {
+	issuerLabels := map[string]string{bootstrapTokenIssuerLabel: bootstrapTokenIssuer}
+
+	tests := []struct {
+		name             string
+		csr              *certv1.CertificateSigningRequest
+		secret           *corev1.Secret
+		deleteToken      bool
+		expectTokenAlive bool
+	}{
+		{
+			name:             "approved but not yet issued",
+			csr:              newTestCsr("system:bootstrap:"+testTokenId, true, nil),
+			secret:           newTestBootstrapTokenSecret(issuerLabels),
+			deleteToken:      true,
+			expectTokenAlive: true,
+		},
+		{
+			name:             "issued",
+			csr:              newTestCsr("system:bootstrap:"+testTokenId, true, []byte("certificate")),
+			secret:           newTestBootstrapTokenSecret(issuerLabels),
+			deleteToken:      true,
+			expectTokenAlive: false,
+		},
+		{
+			name:             "issued with deletion disabled",
+			csr:              newTestCsr("system:bootstrap:"+testTokenId, true, []byte("certificate")),
+			secret:           newTestBootstrapTokenSecret(issuerLabels),
+			deleteToken:      false,
+			expectTokenAlive: true,
+		},
+		{
+			name:             "issued for a token from another issuer",
+			csr:              newTestCsr("system:bootstrap:"+testTokenId, true, []byte("certificate")),
+			secret:           newTestBootstrapTokenSecret(nil),
+			deleteToken:      true,
+			expectTokenAlive: true,
+		},
+		{
+			name:             "issued to a node renewing its certificate",
+			csr:              newTestCsr("system:node:"+testTokenId, true, []byte("certificate")),
+			secret:           newTestBootstrapTokenSecret(issuerLabels),
+			deleteToken:      true,
+			expectTokenAlive: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = clientgoscheme.AddToScheme(scheme)
+
+			kubeclient := k8sfake.NewSimpleClientset(test.csr, test.secret)
+			r := &csrReconciler{
+				Client:                   fake.NewClientBuilder().WithScheme(scheme).WithObjects(test.csr).Build(),
+				Kubeclient:               kubeclient,
+				DeleteTokenAfterApproval: test.deleteToken,
+				Log:                      logr.Discard(),
+			}
+
+			_, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: test.csr.Name}})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, action := range kubeclient.Actions() {
+				if action.GetResource().Resource == "certificatesigningrequests" {
+					t.Errorf("expected an already approved csr not to be updated, got %s", action.GetVerb())
+				}
+			}
+
+			_, err = kubeclient.CoreV1().Secrets(metav1.NamespaceSystem).Get(context.Background(), test.secret.Name, metav1.GetOptions{})
+			if test.expectTokenAlive && err != nil {
+				t.Errorf("expected bootstrap token to remain, got %v", err)
+			}
+			if !test.expectTokenAlive && !apierrors.IsNotFound(err) {
+				t.Errorf("expected bootstrap token to be deleted, got %v", err)
+			}
+		})
+	}
+}
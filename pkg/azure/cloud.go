@@ -0,0 +1,225 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+const (
+	AZURE_PUBLIC_CLOUD        = "AzurePublicCloud"
+	AZURE_CHINA_CLOUD         = "AzureChinaCloud"
+	AZURE_US_GOVERNMENT_CLOUD = "AzureUSGovernmentCloud"
+
+	AZURE_JSON_PATH = "/etc/kubernetes/azure.json"
+
+	// DEFAULT_AUDIENCE is the application clients request Azure AD tokens for, and the
+	// server expects tokens to be issued for, unless configured otherwise.
+	DEFAULT_AUDIENCE = "7319c514-987d-4e9b-ac3d-d38c4f427f4c"
+
+	metadataEndpointsApiVersion = "2019-05-01"
+
+	// openIdConfigurationTenantPlaceholder stands in for the tenant in the issuer of
+	// the common endpoint's OpenID configuration.
+	openIdConfigurationTenantPlaceholder = "{tenantid}/"
+)
+
+// Environment describes the endpoints of the Azure cloud the nodes and the
+// server run in.
+type Environment struct {
+	Name                         string
+	ActiveDirectoryAuthorityHost string
+	ResourceManagerEndpoint      string
+	ResourceManagerAudience      string
+	// TokenIssuerHost is the issuer host of Azure AD v1.0 access tokens, if any.
+	TokenIssuerHost string
+}
+
+var (
+	AzurePublic = &Environment{
+		Name:                         AZURE_PUBLIC_CLOUD,
+		ActiveDirectoryAuthorityHost: "https://login.microsoftonline.com/",
+		ResourceManagerEndpoint:      "https://management.azure.com/",
+		ResourceManagerAudience:      "https://management.core.windows.net/",
+		TokenIssuerHost:              "https://sts.windows.net/",
+	}
+	AzureChina = &Environment{
+		Name:                         AZURE_CHINA_CLOUD,
+		ActiveDirectoryAuthorityHost: "https://login.chinacloudapi.cn/",
+		ResourceManagerEndpoint:      "https://management.chinacloudapi.cn/",
+		ResourceManagerAudience:      "https://management.core.chinacloudapi.cn/",
+		TokenIssuerHost:              "https://sts.chinacloudapi.cn/",
+	}
+	AzureUSGovernment = &Environment{
+		Name:                         AZURE_US_GOVERNMENT_CLOUD,
+		ActiveDirectoryAuthorityHost: "https://login.microsoftonline.us/",
+		ResourceManagerEndpoint:      "https://management.usgovcloudapi.net/",
+		ResourceManagerAudience:      "https://management.core.usgovcloudapi.net/",
+		TokenIssuerHost:              "https://sts.windows.net/",
+	}
+)
+
+// GetEnvironment returns the environment for a well-known cloud name as found in
+// azure.json. Any other cloud name is treated as a custom cloud, whose endpoints
+// are discovered from resourceManagerEndpoint.
+func GetEnvironment(name string, resourceManagerEndpoint string) (*Environment, error) {
+	switch strings.ToLower(name) {
+	case "", "azurepublic", strings.ToLower(AZURE_PUBLIC_CLOUD):
+		return AzurePublic, nil
+	case "azurechina", strings.ToLower(AZURE_CHINA_CLOUD):
+		return AzureChina, nil
+	case "azureusgovernment", strings.ToLower(AZURE_US_GOVERNMENT_CLOUD):
+		return AzureUSGovernment, nil
+	}
+
+	if resourceManagerEndpoint == "" {
+		return nil, fmt.Errorf("cloud %s is not a known cloud and no resource manager endpoint was supplied", name)
+	}
+
+	return getCustomEnvironment(name, resourceManagerEndpoint)
+}
+
+// GetEnvironmentFromAzureJson returns the environment for the cloud configured in
+// azure.json.
+func GetEnvironmentFromAzureJson(azureConfig *KubeletAzureJson) (*Environment, error) {
+	return GetEnvironment(azureConfig.Cloud, azureConfig.ResourceManagerEndpoint)
+}
+
+// LoadAzureJson reads and parses an azure.json file.
+func LoadAzureJson(path string) (*KubeletAzureJson, error) {
+	azureJson, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	azureConfig := &KubeletAzureJson{}
+	err = json.Unmarshal(azureJson, azureConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %v", path, err)
+	}
+
+	return azureConfig, nil
+}
+
+func getCustomEnvironment(name string, resourceManagerEndpoint string) (*Environment, error) {
+	resourceManagerEndpoint = withTrailingSlash(resourceManagerEndpoint)
+	url := fmt.Sprintf("%smetadata/endpoints?api-version=%s", resourceManagerEndpoint, metadataEndpointsApiVersion)
+
+	client := http.Client{Timeout: 30 * time.Second}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve cloud metadata from %s: %v", url, err)
+	}
+
+	defer response.Body.Close()
+	responseBody, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to retrieve cloud metadata from %s: status %d", url, response.StatusCode)
+	}
+
+	metadata := &metadataEndpoints{}
+	err = json.Unmarshal(responseBody, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cloud metadata from %s: %v", url, err)
+	}
+
+	if metadata.Authentication.LoginEndpoint == "" || len(metadata.Authentication.Audiences) == 0 {
+		return nil, fmt.Errorf("cloud metadata from %s is missing authentication endpoints", url)
+	}
+
+	return &Environment{
+		Name:                         name,
+		ActiveDirectoryAuthorityHost: withTrailingSlash(metadata.Authentication.LoginEndpoint),
+		ResourceManagerEndpoint:      resourceManagerEndpoint,
+		ResourceManagerAudience:      metadata.Authentication.Audiences[0],
+	}, nil
+}
+
+// DiscoverTokenIssuerHost returns a copy of the environment with the issuer host of
+// Azure AD v1.0 access tokens discovered from the OpenID configuration of the
+// authority's common endpoint, whose issuer is in the form
+// https://sts.windows.net/{tenantid}/. Custom clouds need this to accept v1.0 tokens.
+func (e *Environment) DiscoverTokenIssuerHost() (*Environment, error) {
+	url := e.ActiveDirectoryAuthorityHost + "common/.well-known/openid-configuration"
+
+	client := http.Client{Timeout: 30 * time.Second}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve OpenID configuration from %s: %v", url, err)
+	}
+
+	defer response.Body.Close()
+	responseBody, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to retrieve OpenID configuration from %s: status %d", url, response.StatusCode)
+	}
+
+	configuration := &openIdConfiguration{}
+	err = json.Unmarshal(responseBody, configuration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal OpenID configuration from %s: %v", url, err)
+	}
+
+	if !strings.HasSuffix(configuration.Issuer, "/"+openIdConfigurationTenantPlaceholder) {
+		return nil, fmt.Errorf("issuer %q in OpenID configuration from %s does not end in %s", configuration.Issuer, url, openIdConfigurationTenantPlaceholder)
+	}
+
+	return e.WithTokenIssuerHost(strings.TrimSuffix(configuration.Issuer, openIdConfigurationTenantPlaceholder)), nil
+}
+
+// WithTokenIssuerHost returns a copy of the environment which accepts v1.0 access
+// tokens issued by tokenIssuerHost.
+func (e *Environment) WithTokenIssuerHost(tokenIssuerHost string) *Environment {
+	environment := *e
+	environment.TokenIssuerHost = withTrailingSlash(tokenIssuerHost)
+	return &environment
+}
+
+// Configuration returns the azcore cloud configuration for ARM and azidentity clients.
+func (e *Environment) Configuration() cloud.Configuration {
+	return cloud.Configuration{
+		ActiveDirectoryAuthorityHost: e.ActiveDirectoryAuthorityHost,
+		Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {
+				Audience: e.ResourceManagerAudience,
+				Endpoint: e.ResourceManagerEndpoint,
+			},
+		},
+	}
+}
+
+// Authority returns the Azure AD authority URL for the given tenant.
+func (e *Environment) Authority(tenantId string) string {
+	return e.ActiveDirectoryAuthorityHost + tenantId
+}
+
+// JwksUrl returns the Azure AD signing key discovery endpoint.
+func (e *Environment) JwksUrl() string {
+	return e.ActiveDirectoryAuthorityHost + "common/discovery/v2.0/keys"
+}
+
+// TokenIssuers returns the valid issuers of Azure AD v1.0 and v2.0 access tokens
+// for the given tenant.
+func (e *Environment) TokenIssuers(tenantId string) []string {
+	issuers := []string{
+		e.ActiveDirectoryAuthorityHost + tenantId + "/v2.0",
+		e.ActiveDirectoryAuthorityHost + tenantId + "/",
+	}
+	if e.TokenIssuerHost != "" {
+		issuers = append(issuers, e.TokenIssuerHost+tenantId+"/")
+	}
+	return issuers
+}
+
+func withTrailingSlash(url string) string {
+	if strings.HasSuffix(url, "/") {
+		return url
+	}
+	return url + "/"
+}
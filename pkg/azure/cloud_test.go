@@ -0,0 +1,178 @@
+package azure
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetEnvironmentWellKnownClouds(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected *Environment
+	}{
+		{"", AzurePublic},
+		{"AzurePublicCloud", AzurePublic},
+		{"azurepubliccloud", AzurePublic},
+		{"AzurePublic", AzurePublic},
+		{"AzureChinaCloud", AzureChina},
+		{"AZURECHINA", AzureChina},
+		{"AzureUSGovernmentCloud", AzureUSGovernment},
+		{"azureusgovernment", AzureUSGovernment},
+	}
+
+	for _, test := range tests {
+		// the resource manager endpoint must not be queried for well-known clouds
+		environment, err := GetEnvironment(test.name, "http://127.0.0.1:0/")
+		if err != nil {
+			t.Errorf("unexpected error for cloud %q: %v", test.name, err)
+			continue
+		}
+		if environment != test.expected {
+			t.Errorf("expected cloud %q to be %s, got %s", test.name, test.expected.Name, environment.Name)
+		}
+	}
+}
+
+func TestGetEnvironmentUnknownCloudWithoutEndpoint(t *testing.T) {
+	if _, err := GetEnvironment("AzureStackCloud", ""); err == nil {
+		t.Errorf("expected an error for an unknown cloud without a resource manager endpoint")
+	}
+}
+
+// newCustomCloudServer serves the ARM cloud metadata of a custom cloud whose Azure AD
+// authority is at /login/ on the same server. The authority publishes issuer in its
+// OpenID configuration unless issuer is empty.
+func newCustomCloudServer(t *testing.T, metadataStatus int, audiences []string, issuer string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata/endpoints", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api-version") != metadataEndpointsApiVersion {
+			http.Error(w, "unsupported api-version", http.StatusBadRequest)
+			return
+		}
+		if metadataStatus != http.StatusOK {
+			http.Error(w, "unavailable", metadataStatus)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"authentication": map[string]interface{}{
+				"loginEndpoint": "http://" + r.Host + "/login",
+				"audiences":     audiences,
+			},
+		})
+	})
+	mux.HandleFunc("/login/common/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		if issuer == "" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"issuer": issuer})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetEnvironmentCustomCloud(t *testing.T) {
+	audiences := []string{"https://management.core.stack.local/", "https://management.stack.local/"}
+	server := newCustomCloudServer(t, http.StatusOK, audiences, "")
+
+	// the endpoint may be configured with or without a trailing slash
+	for _, endpoint := range []string{server.URL, server.URL + "/"} {
+		environment, err := GetEnvironment("AzureStackCloud", endpoint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := Environment{
+			Name:                         "AzureStackCloud",
+			ActiveDirectoryAuthorityHost: server.URL + "/login/",
+			ResourceManagerEndpoint:      server.URL + "/",
+			ResourceManagerAudience:      audiences[0],
+		}
+		if *environment != expected {
+			t.Errorf("expected %+v, got %+v", expected, *environment)
+		}
+	}
+}
+
+func TestGetEnvironmentCustomCloudErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		audiences []string
+	}{
+		{"metadata unavailable", http.StatusServiceUnavailable, []string{"https://management.core.stack.local/"}},
+		{"metadata without audiences", http.StatusOK, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newCustomCloudServer(t, test.status, test.audiences, "")
+			if _, err := GetEnvironment("AzureStackCloud", server.URL); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestDiscoverTokenIssuerHost(t *testing.T) {
+	const tenantId = "33333333-3333-3333-3333-333333333333"
+
+	tests := []struct {
+		name      string
+		issuer    string
+		expected  string
+		expectErr bool
+	}{
+		{"v1.0 issuer", "https://sts.stack.local/{tenantid}/", "https://sts.stack.local/", false},
+		{"not published", "", "", true},
+		{"issuer without tenant placeholder", "https://sts.stack.local/", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newCustomCloudServer(t, http.StatusOK, []string{"https://management.core.stack.local/"}, test.issuer)
+			environment, err := GetEnvironment("AzureStackCloud", server.URL)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			discovered, err := environment.DiscoverTokenIssuerHost()
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, got issuer host %q", discovered.TokenIssuerHost)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if discovered.TokenIssuerHost != test.expected {
+				t.Errorf("expected issuer host %q, got %q", test.expected, discovered.TokenIssuerHost)
+			}
+			if environment.TokenIssuerHost != "" {
+				t.Errorf("expected the original environment not to be modified")
+			}
+
+			issuers := discovered.TokenIssuers(tenantId)
+			if issuers[len(issuers)-1] != test.expected+tenantId+"/" {
+				t.Errorf("expected the v1.0 issuer to be accepted, got %v", issuers)
+			}
+		})
+	}
+}
+
+func TestWithTokenIssuerHost(t *testing.T) {
+	environment := AzurePublic.WithTokenIssuerHost("https://sts.example.com")
+	if environment.TokenIssuerHost != "https://sts.example.com/" {
+		t.Errorf("expected a trailing slash to be added, got %q", environment.TokenIssuerHost)
+	}
+	if AzurePublic.TokenIssuerHost != "https://sts.windows.net/" {
+		t.Errorf("expected AzurePublic not to be modified, got %q", AzurePublic.TokenIssuerHost)
+	}
+}
@@ -0,0 +1,27 @@
+package azure
+
+// KubeletAzureJson is the subset of /etc/kubernetes/azure.json used by the
+// client and server.
+type KubeletAzureJson struct {
+	Cloud                   string `json:"cloud"`
+	ClientId                string `json:"aadClientId"`
+	ClientSecret            string `json:"aadClientSecret"`
+	ResourceManagerEndpoint string `json:"resourceManagerEndpoint"`
+	TenantId                string `json:"tenantId"`
+	UserAssignedIdentityID  string `json:"userAssignedIdentityID"`
+}
+
+// metadataEndpoints is the response from an ARM endpoint's
+// /metadata/endpoints API, used to discover custom clouds.
+type metadataEndpoints struct {
+	Authentication struct {
+		LoginEndpoint string   `json:"loginEndpoint"`
+		Audiences     []string `json:"audiences"`
+	} `json:"authentication"`
+}
+
+// openIdConfiguration is the part of an Azure AD authority's OpenID configuration
+// used to discover the issuer of v1.0 access tokens in custom clouds.
+type openIdConfiguration struct {
+	Issuer string `json:"issuer"`
+}
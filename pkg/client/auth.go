@@ -2,42 +2,48 @@ package client
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"os"
 
+	"github.com/Azure/aks-tls-bootstrap/pkg/azure"
 	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
 	"github.com/sirupsen/logrus"
 )
 
-func GetAuthToken(log *logrus.Logger, clientId string) (string, error) {
+func GetAuthToken(log *logrus.Logger, clientId string, cloudName string, audience string) (string, error) {
 	authMethod := ""
-	azureConfig := &kubeletAzureJson{}
+	azureConfig, err := azure.LoadAzureJson(azure.AZURE_JSON_PATH)
+	if err != nil {
+		log.WithError(err).Info("failed to load azure.json")
+		azureConfig = &azure.KubeletAzureJson{}
+	}
 
 	if clientId != "" {
 		authMethod = "msi"
-	} else {
-		azureJson, err := os.ReadFile("/etc/kubernetes/azure.json")
-		if err != nil {
-			log.WithError(err).Info("failed to parse /etc/kubernetes/azure.json")
+	} else if err == nil {
+		if azureConfig.ClientId == "msi" {
+			authMethod = "msi"
+			clientId = azureConfig.UserAssignedIdentityID
 		} else {
-			err := json.Unmarshal(azureJson, azureConfig)
-			if err != nil {
-				log.WithError(err).Info("failed to unmarshal /etc/kubernetes/azure.json")
-			} else {
-				if azureConfig.ClientId == "msi" {
-					authMethod = "msi"
-					clientId = azureConfig.UserAssignedIdentityID
-				} else {
-					authMethod = "sp"
-				}
-			}
+			authMethod = "sp"
 		}
 	}
 
+	if cloudName == "" {
+		cloudName = azureConfig.Cloud
+	}
+	cloud, err := azure.GetEnvironment(cloudName, azureConfig.ResourceManagerEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine Azure cloud: %v", err)
+	}
+	log.WithField("cloud", cloud.Name).Debug("determined Azure cloud")
+
+	if audience == "" {
+		audience = azure.DEFAULT_AUDIENCE
+	}
+
 	if authMethod == "msi" {
 		log.Info("retrieving IMDS access token")
-		token, err := GetMSIToken(clientId)
+		token, err := GetMSIToken(clientId, audience)
 		if err != nil {
 			return "", err
 		}
@@ -49,12 +55,12 @@ func GetAuthToken(log *logrus.Logger, clientId string) (string, error) {
 			return "", fmt.Errorf("failed to create secret from azure.json: %v", err)
 		}
 
-		client, err := confidential.New(azureConfig.ClientId, credential, confidential.WithAuthority("https://login.microsoftonline.com/"+azureConfig.TenantId))
+		client, err := confidential.New(azureConfig.ClientId, credential, confidential.WithAuthority(cloud.Authority(azureConfig.TenantId)))
 		if err != nil {
 			return "", fmt.Errorf("failed to create client from azure.json sp/secret: %v", err)
 		}
 
-		token, err := client.AcquireTokenByCredential(context.Background(), []string{"7319c514-987d-4e9b-ac3d-d38c4f427f4c/.default"})
+		token, err := client.AcquireTokenByCredential(context.Background(), []string{audience + "/.default"})
 		if err != nil {
 			return "", fmt.Errorf("failed to acquire token via service principal: %v", err)
 		}
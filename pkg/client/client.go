@@ -9,20 +9,23 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"time"
 
 	pb "github.com/Azure/aks-tls-bootstrap/pkg/proto"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/status"
 )
 
 var (
 	log *logrus.Logger
 )
 
-func GetBootstrapToken(mainLogger *logrus.Logger, clientId string, nextProto string) (string, error) {
+func GetBootstrapToken(mainLogger *logrus.Logger, clientId string, nextProto string, cloudName string, audience string) (string, error) {
 	log = mainLogger
 	log.WithField("KUBERNETES_EXEC_INFO", os.Getenv("KUBERNETES_EXEC_INFO")).Debug("parsing KUBERNETES_EXEC_INFO variable")
 	kubernetesExecInfoVar := os.Getenv("KUBERNETES_EXEC_INFO")
@@ -62,7 +65,7 @@ func GetBootstrapToken(mainLogger *logrus.Logger, clientId string, nextProto str
 	}
 
 	log.Info("retrieving Azure AD token")
-	token, err := GetAuthToken(log, clientId)
+	token, err := GetAuthToken(log, clientId, cloudName, audience)
 	if err != nil {
 		return "", err
 	}
@@ -87,43 +90,71 @@ func GetBootstrapToken(mainLogger *logrus.Logger, clientId string, nextProto str
 		return "", fmt.Errorf("failed to retrieve instance metadata from IMDS: %v", err)
 	}
 
+	var tokenReply *pb.TokenResponse
+	delay := initialRetryDelay
+	for attempt := 1; ; attempt++ {
+		tokenReply, err = requestToken(pbClient, server, instanceData.Compute.ResourceID)
+		if err == nil {
+			break
+		}
+
+		wait, retryable := retryDelay(err, delay)
+		if !retryable || attempt >= maxTokenRequestAttempts {
+			return "", err
+		}
+		log.WithError(err).WithFields(logrus.Fields{
+			"code":    status.Code(err).String(),
+			"reason":  errorReason(err),
+			"attempt": attempt,
+		}).Warnf("token request failed, retrying in %s", wait)
+		time.Sleep(wait)
+		delay *= 2
+	}
+	log.Info("received token reply")
+
+	//execCredential := &ExecCredential{}
+	execCredential.APIVersion = "client.authentication.k8s.io/v1"
+	execCredential.Kind = "ExecCredential"
+	execCredential.Status.Token = tokenReply.Token
+	execCredential.Status.ExpirationTimestamp = tokenReply.Expiration
+
+	execCredentialBytes, err := json.Marshal(execCredential)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal execCredential")
+	}
+	return string(execCredentialBytes), nil
+}
+
+func requestToken(pbClient pb.AKSBootstrapTokenRequestClient, server string, resourceId string) (*pb.TokenResponse, error) {
 	log.Infof("retrieving nonce from TLS bootstrap token server at %s", server)
 	nonceRequest := pb.NonceRequest{
-		ResourceId: instanceData.Compute.ResourceID,
+		ResourceId: resourceId,
 	}
 	nonce, err := pbClient.GetNonce(context.Background(), &nonceRequest)
 	if err != nil {
-		return "", fmt.Errorf("failed to retrieve a nonce: %v", err)
+		// returned unwrapped so that the status code and details are preserved for retries
+		log.WithError(err).Error("failed to retrieve a nonce")
+		return nil, err
 	}
 	log.Infof("nonce reply is %s", nonce.Nonce)
 
 	log.Info("retrieving IMDS attested data")
 	attestedData, err := GetAttestedData(nonce.Nonce)
 	if err != nil {
-		return "", fmt.Errorf("failed to retrieve attested data from IMDS: %v", err)
+		return nil, status.Errorf(codes.Unavailable, "failed to retrieve attested data from IMDS: %v", err)
 	}
 
 	log.Info("retrieving bootstrap token from TLS bootstrap token server")
 	tokenRequest := pb.TokenRequest{
-		ResourceId:   instanceData.Compute.ResourceID,
+		ResourceId:   resourceId,
 		Nonce:        nonce.Nonce,
 		AttestedData: attestedData.Signature,
 	}
 	tokenReply, err := pbClient.GetToken(context.Background(), &tokenRequest)
 	if err != nil {
-		return "", fmt.Errorf("failed to retrieve a token: %v", err)
+		log.WithError(err).Error("failed to retrieve a token")
+		return nil, err
 	}
-	log.Info("received token reply")
-
-	//execCredential := &ExecCredential{}
-	execCredential.APIVersion = "client.authentication.k8s.io/v1"
-	execCredential.Kind = "ExecCredential"
-	execCredential.Status.Token = tokenReply.Token
-	execCredential.Status.ExpirationTimestamp = tokenReply.Expiration
 
-	execCredentialBytes, err := json.Marshal(execCredential)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal execCredential")
-	}
-	return string(execCredentialBytes), nil
+	return tokenReply, nil
 }
@@ -5,13 +5,15 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+
+	"github.com/Azure/aks-tls-bootstrap/pkg/redact"
 )
 
-func GetMSIToken(clientId string) (*TokenResponseJson, error) {
+func GetMSIToken(clientId string, resource string) (*TokenResponseJson, error) {
 	url := "http://169.254.169.254/metadata/identity/oauth2/token"
 	queryParameters := map[string]string{
 		"api-version": "2018-02-01",
-		"resource":    "https://management.azure.com/",
+		"resource":    resource,
 	}
 	if clientId != "" {
 		queryParameters["client_id"] = clientId
@@ -27,7 +29,7 @@ func GetMSIToken(clientId string) (*TokenResponseJson, error) {
 		return nil, fmt.Errorf("failed to retrieve IMDS MSI token (%s): %s", data.Error, data.ErrorDescription)
 	}
 
-	log.WithField("accessToken", data.AccessToken).Debugf("retrieved access token")
+	log.WithField("accessToken", redact.JWT(data.AccessToken)).Debugf("retrieved access token")
 	return data, nil
 }
 
@@ -88,7 +90,7 @@ func getImdsData(url string, queryParameters map[string]string, responseObject i
 	defer response.Body.Close()
 	responseBody, _ := ioutil.ReadAll(response.Body)
 
-	log.WithField("responseBody", string(responseBody)).Debug("received IMDS reply")
+	log.WithField("responseBody", redact.JSON(responseBody)).Debug("received IMDS reply")
 
 	err = json.Unmarshal(responseBody, responseObject)
 	if err != nil {
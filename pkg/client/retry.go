@@ -0,0 +1,38 @@
+package client
+
+import (
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxTokenRequestAttempts = 5
+	initialRetryDelay       = 1 * time.Second
+)
+
+// retryDelay returns how long to wait before retrying a failed token request, and
+// whether it should be retried at all. Authorization failures are never retried.
+func retryDelay(err error, delay time.Duration) (time.Duration, bool) {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return delay, true
+	case codes.FailedPrecondition:
+		// the nonce expired or was already used; request a new one straight away
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// errorReason returns the reason from the ErrorInfo detail of a gRPC error, if any.
+func errorReason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if errorInfo, ok := detail.(*errdetails.ErrorInfo); ok {
+			return errorInfo.Reason
+		}
+	}
+	return ""
+}
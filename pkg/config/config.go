@@ -0,0 +1,211 @@
+// Package config defines the versioned configuration file of the bootstrap server.
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/aks-tls-bootstrap/pkg/kubeclient"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	API_VERSION = "tlsbootstrap.aks.azure.com/v1alpha1"
+	KIND        = "TlsBootstrapServerConfiguration"
+)
+
+// ServerConfiguration is the configuration file of the bootstrap server. It may be
+// written as YAML or JSON; unknown fields are rejected.
+type ServerConfiguration struct {
+	APIVersion    string                     `json:"apiVersion"`
+	Kind          string                     `json:"kind"`
+	Listen        ListenConfiguration        `json:"listen"`
+	Kubernetes    kubeclient.Options         `json:"kubernetes"`
+	TLS           TLSConfiguration           `json:"tls"`
+	Azure         AzureConfiguration         `json:"azure"`
+	Attestation   AttestationConfiguration   `json:"attestation"`
+	Authorization AuthorizationConfiguration `json:"authorization"`
+	Admission     AdmissionConfiguration     `json:"admission"`
+	Nonce         NonceConfiguration         `json:"nonce"`
+	Token         TokenConfiguration         `json:"token"`
+	Audit         AuditConfiguration         `json:"audit"`
+	Logging       LoggingConfiguration       `json:"logging"`
+}
+
+type ListenConfiguration struct {
+	Hostname         string `json:"hostname"`
+	Port             int    `json:"port"`
+	HealthAddress    string `json:"healthAddress"`
+	MetricsAddress   string `json:"metricsAddress"`
+	EnableReflection bool   `json:"enableReflection"`
+}
+
+type TLSConfiguration struct {
+	CertFile     string `json:"certFile"`
+	KeyFile      string `json:"keyFile"`
+	ClientCAFile string `json:"clientCAFile"`
+	ClientAuth   string `json:"clientAuth"`
+}
+
+type AzureConfiguration struct {
+	// ConfigPath is the azure.json holding the ARM credential, and the cloud and
+	// tenant if they are not set below.
+	ConfigPath              string `json:"configPath"`
+	Cloud                   string `json:"cloud"`
+	ResourceManagerEndpoint string `json:"resourceManagerEndpoint"`
+	TenantId                string `json:"tenantId"`
+	JwksUrl                 string `json:"jwksUrl"`
+	// TokenIssuerHost is the issuer host of Azure AD v1.0 access tokens. It is only
+	// needed for custom clouds whose authority does not publish it.
+	TokenIssuerHost          string          `json:"tokenIssuerHost"`
+	JwksRefreshInterval      metaV1.Duration `json:"jwksRefreshInterval"`
+	Audience                 string          `json:"audience"`
+	AllowedSigningAlgorithms []string        `json:"allowedSigningAlgorithms"`
+	ClockSkew                metaV1.Duration `json:"clockSkew"`
+}
+
+type AttestationConfiguration struct {
+	SignerHostName      string `json:"signerHostName"`
+	RootCertDir         string `json:"rootCertDir"`
+	IntermediateCertDir string `json:"intermediateCertDir"`
+}
+
+type AuthorizationConfiguration struct {
+	AllowedClientIds             []string                       `json:"allowedClientIds"`
+	AllowedCertificateIdentities []string                       `json:"allowedCertificateIdentities"`
+	AllowedIdentities            AllowedIdentitiesConfiguration `json:"allowedIdentities"`
+	AllowedAppIds                []string                       `json:"allowedAppIds"`
+	AllowedAppRoles              []string                       `json:"allowedAppRoles"`
+	AllowedGroupIds              []string                       `json:"allowedGroupIds"`
+	RejectGroupOverage           bool                           `json:"rejectGroupOverage"`
+}
+
+type AllowedIdentitiesConfiguration struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type AdmissionConfiguration struct {
+	AllowedSubscriptionIds []string `json:"allowedSubscriptionIds"`
+	AllowedResourceGroups  []string `json:"allowedResourceGroups"`
+	AllowedVmssNames       []string `json:"allowedVmssNames"`
+	// RequiredTags are tag rules in the form <tag>=<exact|prefix|regex>:<value>.
+	// Regular expressions must match the whole tag value.
+	RequiredTags []string `json:"requiredTags"`
+	NodePoolTag  string   `json:"nodePoolTag"`
+}
+
+type NonceConfiguration struct {
+	Store                   string          `json:"store"`
+	Namespace               string          `json:"namespace"`
+	Lifetime                metaV1.Duration `json:"lifetime"`
+	ExpirationCheckInterval metaV1.Duration `json:"expirationCheckInterval"`
+}
+
+type TokenConfiguration struct {
+	Lifetime metaV1.Duration `json:"lifetime"`
+	// GCInterval is how often expired bootstrap tokens issued by the server are deleted.
+	GCInterval metaV1.Duration `json:"gcInterval"`
+}
+
+type AuditConfiguration struct {
+	LogPath          string `json:"logPath"`
+	KubernetesEvents bool   `json:"kubernetesEvents"`
+}
+
+type LoggingConfiguration struct {
+	Format             string `json:"format"`
+	Debug              bool   `json:"debug"`
+	DebugUnsafeSecrets bool   `json:"debugUnsafeSecrets"`
+}
+
+// Load reads a configuration file over cfg, which holds the defaults for any setting
+// not present in the file. Unknown fields are an error.
+func Load(path string, cfg *ServerConfiguration) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file %s: %v", path, err)
+	}
+
+	err = yaml.UnmarshalStrict(data, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to parse configuration file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// Validate checks the configuration and returns every problem found, rather than
+// stopping at the first. Values which are only meaningful to the server, such as
+// tls.clientAuth or nonce.store, are left to the server to check.
+func (c *ServerConfiguration) Validate() []error {
+	errs := []error{}
+	addError := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	if c.APIVersion != API_VERSION {
+		addError("apiVersion must be %s, got %q", API_VERSION, c.APIVersion)
+	}
+	if c.Kind != KIND {
+		addError("kind must be %s, got %q", KIND, c.Kind)
+	}
+
+	if c.Listen.Port < 1 || c.Listen.Port > 65535 {
+		addError("listen.port must be between 1 and 65535, got %d", c.Listen.Port)
+	}
+
+	err := c.Kubernetes.Validate()
+	if err != nil {
+		addError("kubernetes: %v", err)
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		addError("tls.certFile and tls.keyFile must be set together")
+	}
+	if c.Azure.TenantId == "" && c.Azure.ConfigPath == "" {
+		addError("azure.tenantId is required when azure.configPath is not set")
+	}
+	if c.Azure.Audience == "" {
+		addError("azure.audience must not be empty")
+	}
+	if len(c.Azure.AllowedSigningAlgorithms) == 0 {
+		addError("azure.allowedSigningAlgorithms must not be empty")
+	}
+	validatePositive(&errs, "azure.jwksRefreshInterval", c.Azure.JwksRefreshInterval.Duration)
+	validatePositive(&errs, "azure.clockSkew", c.Azure.ClockSkew.Duration)
+
+	if c.Attestation.SignerHostName == "" {
+		addError("attestation.signerHostName must not be empty")
+	}
+
+	if c.Authorization.AllowedIdentities.Name != "" && c.Authorization.AllowedIdentities.Namespace == "" {
+		addError("authorization.allowedIdentities.namespace is required when authorization.allowedIdentities.name is set")
+	}
+
+	validatePositive(&errs, "nonce.lifetime", c.Nonce.Lifetime.Duration)
+	validatePositive(&errs, "nonce.expirationCheckInterval", c.Nonce.ExpirationCheckInterval.Duration)
+	validatePositive(&errs, "token.lifetime", c.Token.Lifetime.Duration)
+	validatePositive(&errs, "token.gcInterval", c.Token.GCInterval.Duration)
+	if c.Token.Lifetime.Duration < c.Nonce.Lifetime.Duration {
+		addError("token.lifetime %s must not be shorter than nonce.lifetime %s", c.Token.Lifetime.Duration, c.Nonce.Lifetime.Duration)
+	}
+
+	switch strings.ToLower(c.Logging.Format) {
+	case "json", "text":
+	default:
+		addError("logging.format must be json or text, got %q", c.Logging.Format)
+	}
+
+	return errs
+}
+
+func validatePositive(errs *[]error, field string, value time.Duration) {
+	if value <= 0 {
+		*errs = append(*errs, fmt.Errorf("%s must be positive, got %s", field, value))
+	}
+}
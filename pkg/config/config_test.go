@@ -0,0 +1,170 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validConfiguration returns a configuration which passes Validate.
+func validConfiguration() *ServerConfiguration {
+	return &ServerConfiguration{
+		APIVersion: API_VERSION,
+		Kind:       KIND,
+		Listen:     ListenConfiguration{Port: 9123},
+		Azure: AzureConfiguration{
+			TenantId:                 "33333333-3333-3333-3333-333333333333",
+			Audience:                 "audience",
+			AllowedSigningAlgorithms: []string{"RS256"},
+			JwksRefreshInterval:      metaV1.Duration{Duration: time.Hour},
+			ClockSkew:                metaV1.Duration{Duration: time.Minute},
+		},
+		Attestation: AttestationConfiguration{SignerHostName: "metadata.azure.com"},
+		Nonce: NonceConfiguration{
+			Lifetime:                metaV1.Duration{Duration: time.Minute},
+			ExpirationCheckInterval: metaV1.Duration{Duration: time.Minute},
+		},
+		Token: TokenConfiguration{
+			Lifetime:   metaV1.Duration{Duration: time.Minute},
+			GCInterval: metaV1.Duration{Duration: time.Minute},
+		},
+		Logging: LoggingConfiguration{Format: "json"},
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write configuration file: %v", err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfigFile(t, `
+apiVersion: tlsbootstrap.aks.azure.com/v1alpha1
+kind: TlsBootstrapServerConfiguration
+listen:
+  port: 8443
+nonce:
+  lifetime: 45s
+`)
+
+	cfg := validConfiguration()
+	if err := Load(path, cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Listen.Port != 8443 || cfg.Nonce.Lifetime.Duration != 45*time.Second {
+		t.Errorf("expected values from the file to be loaded, got port %d and nonce lifetime %s", cfg.Listen.Port, cfg.Nonce.Lifetime.Duration)
+	}
+	if cfg.Azure.Audience != "audience" || cfg.Token.Lifetime.Duration != time.Minute {
+		t.Errorf("expected defaults not in the file to be kept, got %+v", cfg)
+	}
+	if errs := cfg.Validate(); len(errs) != 0 {
+		t.Errorf("unexpected validation errors: %v", errs)
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unknown top level field", "apiVersion: " + API_VERSION + "\nkind: " + KIND + "\nlistens:\n  port: 8443\n"},
+		{"unknown nested field", "apiVersion: " + API_VERSION + "\nkind: " + KIND + "\nlisten:\n  prot: 8443\n"},
+		{"duplicate field", "apiVersion: " + API_VERSION + "\napiVersion: " + API_VERSION + "\n"},
+		{"wrong type", "listen:\n  port: https\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Load(writeConfigFile(t, test.content), validConfiguration())
+			if err == nil {
+				t.Errorf("expected the configuration file to be rejected")
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	err := Load(filepath.Join(t.TempDir(), "missing.yaml"), validConfiguration())
+	if err == nil {
+		t.Errorf("expected an error loading a missing file")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(cfg *ServerConfiguration)
+		expected []string
+	}{
+		{
+			name:   "valid",
+			modify: func(cfg *ServerConfiguration) {},
+		},
+		{
+			name:     "wrong apiVersion",
+			modify:   func(cfg *ServerConfiguration) { cfg.APIVersion = "tlsbootstrap.aks.azure.com/v1" },
+			expected: []string{"apiVersion"},
+		},
+		{
+			name:     "wrong kind",
+			modify:   func(cfg *ServerConfiguration) { cfg.Kind = "ServerConfiguration" },
+			expected: []string{"kind"},
+		},
+		{
+			name:     "missing apiVersion and kind",
+			modify:   func(cfg *ServerConfiguration) { cfg.APIVersion, cfg.Kind = "", "" },
+			expected: []string{"apiVersion", "kind"},
+		},
+		{
+			name: "every error reported",
+			modify: func(cfg *ServerConfiguration) {
+				cfg.Listen.Port = 0
+				cfg.Kubernetes.Source = "elsewhere"
+				cfg.TLS.CertFile = "cert.pem"
+				cfg.Azure.TenantId = ""
+				cfg.Azure.Audience = ""
+				cfg.Azure.ClockSkew.Duration = -time.Minute
+				cfg.Authorization.AllowedIdentities.Name = "allowed"
+				cfg.Nonce.Lifetime.Duration = 2 * time.Minute
+				cfg.Logging.Format = "xml"
+			},
+			expected: []string{
+				"listen.port",
+				"kubernetes:",
+				"tls.certFile and tls.keyFile",
+				"azure.tenantId",
+				"azure.audience",
+				"azure.clockSkew",
+				"authorization.allowedIdentities.namespace",
+				"token.lifetime",
+				"logging.format",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := validConfiguration()
+			test.modify(cfg)
+
+			errs := cfg.Validate()
+			if len(errs) != len(test.expected) {
+				t.Fatalf("expected %d errors, got %d: %v", len(test.expected), len(errs), errs)
+			}
+			for i, expected := range test.expected {
+				if !strings.HasPrefix(errs[i].Error(), expected) {
+					t.Errorf("expected error %d to be about %s, got %v", i, expected, errs[i])
+				}
+			}
+		})
+	}
+}
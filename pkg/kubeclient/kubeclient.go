@@ -0,0 +1,155 @@
+// Package kubeclient locates the kubeconfig of the overlay cluster the bootstrap
+// server and approver act on.
+package kubeclient
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// kubeconfig sources
+const (
+	// SOURCE_SECRET reads the kubeconfig from a secret in the hosting cluster, the
+	// layout used when running in the CCP. The hosting cluster is located as
+	// controller-runtime does, from -kubeconfig, $KUBECONFIG or in-cluster config.
+	SOURCE_SECRET = "secret"
+	// SOURCE_FILE reads the kubeconfig from a file.
+	SOURCE_FILE = "file"
+	// SOURCE_IN_CLUSTER uses the service account of the pod, for running directly in
+	// the cluster being bootstrapped.
+	SOURCE_IN_CLUSTER = "in-cluster"
+)
+
+const (
+	DEFAULT_SECRET_NAME = "kubeconfig-file"
+	DEFAULT_SECRET_KEY  = "kubeconfig.yaml"
+)
+
+// Options selects where the kubeconfig comes from. If Source is empty, a file is
+// used when KubeconfigPath is set and the secret otherwise.
+type Options struct {
+	Source         string `json:"source"`
+	KubeconfigPath string `json:"kubeconfigPath"`
+	// SecretNamespace defaults to the POD_NS environment variable.
+	SecretNamespace string `json:"secretNamespace"`
+	// SecretName defaults to DEFAULT_SECRET_NAME.
+	SecretName string `json:"secretName"`
+	// SecretKey defaults to DEFAULT_SECRET_KEY.
+	SecretKey string `json:"secretKey"`
+}
+
+// BindFlags registers flags for each option on the flag set.
+func (o *Options) BindFlags(flags *flag.FlagSet) {
+	flags.StringVar(&o.Source, "overlay-kubeconfig-source", o.Source, "Where to read the overlay cluster kubeconfig from: secret, file or in-cluster. Defaults to file if -overlay-kubeconfig is set, otherwise secret.")
+	flags.StringVar(&o.KubeconfigPath, "overlay-kubeconfig", o.KubeconfigPath, "Path to the overlay cluster kubeconfig.")
+	flags.StringVar(&o.SecretNamespace, "overlay-kubeconfig-secret-namespace", o.SecretNamespace, "The namespace of the secret holding the overlay cluster kubeconfig. Defaults to $POD_NS.")
+	flags.StringVar(&o.SecretName, "overlay-kubeconfig-secret-name", o.SecretName, "The name of the secret holding the overlay cluster kubeconfig. Defaults to "+DEFAULT_SECRET_NAME+".")
+	flags.StringVar(&o.SecretKey, "overlay-kubeconfig-secret-key", o.SecretKey, "The key of the secret holding the overlay cluster kubeconfig. Defaults to "+DEFAULT_SECRET_KEY+".")
+}
+
+// Validate checks that the source is known, has what it needs and is not combined
+// with options of another source, which would otherwise be silently ignored.
+func (o *Options) Validate() error {
+	source := o.source()
+	switch source {
+	case SOURCE_FILE:
+		if o.KubeconfigPath == "" {
+			return fmt.Errorf("a kubeconfig path is required with kubeconfig source %s", SOURCE_FILE)
+		}
+	case SOURCE_SECRET, SOURCE_IN_CLUSTER:
+		if o.KubeconfigPath != "" {
+			return fmt.Errorf("a kubeconfig path cannot be used with kubeconfig source %s", source)
+		}
+	default:
+		return fmt.Errorf("unknown kubeconfig source %q, expected %s, %s or %s", o.Source, SOURCE_SECRET, SOURCE_FILE, SOURCE_IN_CLUSTER)
+	}
+
+	if source != SOURCE_SECRET && (o.SecretNamespace != "" || o.SecretName != "" || o.SecretKey != "") {
+		return fmt.Errorf("kubeconfig secret options cannot be used with kubeconfig source %s", source)
+	}
+	return nil
+}
+
+func (o *Options) source() string {
+	if o.Source != "" {
+		return o.Source
+	}
+	if o.KubeconfigPath != "" {
+		return SOURCE_FILE
+	}
+	return SOURCE_SECRET
+}
+
+// GetConfig returns the REST config of the overlay cluster.
+func GetConfig(o Options) (*rest.Config, error) {
+	err := o.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.source() {
+	case SOURCE_FILE:
+		cfg, err := clientcmd.BuildConfigFromFlags("", o.KubeconfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig %s: %v", o.KubeconfigPath, err)
+		}
+		return cfg, nil
+	case SOURCE_IN_CLUSTER:
+		cfg, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load in-cluster config: %v", err)
+		}
+		return cfg, nil
+	default:
+		return configFromSecret(o)
+	}
+}
+
+// configFromSecret reads the kubeconfig from a secret in the cluster the pod runs in.
+func configFromSecret(o Options) (*rest.Config, error) {
+	hostingConfig, err := config.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize kubernetes client: %v", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(hostingConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %v", err)
+	}
+
+	namespace := o.SecretNamespace
+	if namespace == "" {
+		namespace = os.Getenv("POD_NS")
+	}
+	if o.SecretName == "" {
+		o.SecretName = DEFAULT_SECRET_NAME
+	}
+	if o.SecretKey == "" {
+		o.SecretKey = DEFAULT_SECRET_KEY
+	}
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), o.SecretName, metaV1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting kubeconfig secret %s/%s: %s", namespace, o.SecretName, err)
+	}
+
+	kubeconfig, ok := secret.Data[o.SecretKey]
+	if !ok || len(kubeconfig) == 0 {
+		return nil, fmt.Errorf("kubeconfig secret %s/%s has no %s key", namespace, o.SecretName, o.SecretKey)
+	}
+
+	cfg, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("parsing overlay kubeconfig: %s", err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,183 @@
+package kubeclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testOverlayHost = "https://overlay.example.com:443"
+
+func kubeconfigFor(host string) []byte {
+	return []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: cluster
+  cluster:
+    server: ` + host + `
+users:
+- name: user
+  user:
+    token: token
+contexts:
+- name: context
+  context:
+    cluster: cluster
+    user: user
+current-context: context
+`)
+}
+
+func writeKubeconfig(t *testing.T, host string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, kubeconfigFor(host), 0600); err != nil {
+		t.Fatalf("failed to write kubeconfig: %v", err)
+	}
+	return path
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   Options
+		expectErr bool
+	}{
+		{name: "defaults to secret", options: Options{}},
+		{name: "secret with options", options: Options{Source: SOURCE_SECRET, SecretNamespace: "ccp", SecretName: "kubeconfig", SecretKey: "config"}},
+		{name: "file implied by path", options: Options{KubeconfigPath: "/etc/kubeconfig"}},
+		{name: "file", options: Options{Source: SOURCE_FILE, KubeconfigPath: "/etc/kubeconfig"}},
+		{name: "in-cluster", options: Options{Source: SOURCE_IN_CLUSTER}},
+		{name: "unknown source", options: Options{Source: "configmap"}, expectErr: true},
+		{name: "file without path", options: Options{Source: SOURCE_FILE}, expectErr: true},
+		{name: "secret with path", options: Options{Source: SOURCE_SECRET, KubeconfigPath: "/etc/kubeconfig"}, expectErr: true},
+		{name: "in-cluster with path", options: Options{Source: SOURCE_IN_CLUSTER, KubeconfigPath: "/etc/kubeconfig"}, expectErr: true},
+		{name: "file with secret name", options: Options{KubeconfigPath: "/etc/kubeconfig", SecretName: "kubeconfig"}, expectErr: true},
+		{name: "in-cluster with secret namespace", options: Options{Source: SOURCE_IN_CLUSTER, SecretNamespace: "ccp"}, expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.options.Validate()
+			if test.expectErr && err == nil {
+				t.Errorf("expected %+v to be rejected", test.options)
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetConfigFromFile(t *testing.T) {
+	cfg, err := GetConfig(Options{KubeconfigPath: writeKubeconfig(t, testOverlayHost)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Host != testOverlayHost {
+		t.Errorf("expected host %s, got %s", testOverlayHost, cfg.Host)
+	}
+
+	if _, err := GetConfig(Options{KubeconfigPath: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Errorf("expected an error for a missing kubeconfig")
+	}
+}
+
+func TestGetConfigInCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+
+	_, err := GetConfig(Options{Source: SOURCE_IN_CLUSTER})
+	if err == nil || !strings.Contains(err.Error(), "in-cluster") {
+		t.Errorf("expected an in-cluster config error outside a cluster, got %v", err)
+	}
+}
+
+func TestGetConfigRejectsInvalidOptions(t *testing.T) {
+	// the file must not be read when the options are invalid
+	_, err := GetConfig(Options{Source: SOURCE_SECRET, KubeconfigPath: writeKubeconfig(t, testOverlayHost)})
+	if err == nil {
+		t.Errorf("expected invalid options to be rejected")
+	}
+}
+
+// newHostingAPIServer serves the given secrets from a local API server and points
+// KUBECONFIG at it, as the hosting cluster the secret is read from.
+func newHostingAPIServer(t *testing.T, secrets ...*coreV1.Secret) {
+	t.Helper()
+
+	hostingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, secret := range secrets {
+			if r.URL.Path == "/api/v1/namespaces/"+secret.Namespace+"/secrets/"+secret.Name {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(secret)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(metaV1.Status{
+			TypeMeta: metaV1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+			Status:   metaV1.StatusFailure,
+			Reason:   metaV1.StatusReasonNotFound,
+			Code:     http.StatusNotFound,
+		})
+	}))
+	t.Cleanup(hostingServer.Close)
+
+	t.Setenv("KUBECONFIG", writeKubeconfig(t, hostingServer.URL))
+}
+
+func newKubeconfigSecret(namespace, name, key string) *coreV1.Secret {
+	return &coreV1.Secret{
+		TypeMeta:   metaV1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+		ObjectMeta: metaV1.ObjectMeta{Namespace: namespace, Name: name},
+		Data:       map[string][]byte{key: kubeconfigFor(testOverlayHost)},
+	}
+}
+
+func TestGetConfigFromSecret(t *testing.T) {
+	newHostingAPIServer(t,
+		newKubeconfigSecret("pod-namespace", DEFAULT_SECRET_NAME, DEFAULT_SECRET_KEY),
+		newKubeconfigSecret("ccp", "overlay", "config"),
+		newKubeconfigSecret("ccp", "wrong-key", "other"),
+	)
+	t.Setenv("POD_NS", "pod-namespace")
+
+	tests := []struct {
+		name      string
+		options   Options
+		expectErr bool
+	}{
+		{name: "defaults", options: Options{}},
+		{name: "configured secret", options: Options{Source: SOURCE_SECRET, SecretNamespace: "ccp", SecretName: "overlay", SecretKey: "config"}},
+		{name: "missing secret", options: Options{SecretNamespace: "ccp", SecretName: "missing"}, expectErr: true},
+		{name: "missing key", options: Options{SecretNamespace: "ccp", SecretName: "wrong-key"}, expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := GetConfig(test.options)
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Host != testOverlayHost {
+				t.Errorf("expected the overlay host %s, got %s", testOverlayHost, cfg.Host)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Package redact masks credentials before they are logged, so that debug logs can
+// be shared without leaking tokens or secrets.
+package redact
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+)
+
+const REDACTED = "REDACTED"
+
+// sensitiveKeys are the JSON and map keys whose values are always masked. Keys are
+// compared case-insensitively.
+var sensitiveKeys = []string{
+	"access_token",
+	"refresh_token",
+	"id_token",
+	"client_secret",
+	"clientSecret",
+	"aadClientSecret",
+	"token-secret",
+	"password",
+	"customData",
+	"userData",
+}
+
+var unsafeSecrets int32
+
+// SetUnsafeSecrets disables redaction so that secrets are logged in full. It should
+// only be enabled explicitly while debugging.
+func SetUnsafeSecrets(enabled bool) {
+	value := int32(0)
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&unsafeSecrets, value)
+}
+
+func unsafe() bool {
+	return atomic.LoadInt32(&unsafeSecrets) == 1
+}
+
+// Secret masks the whole value. Empty values are returned as is so that it remains
+// clear whether a secret was set.
+func Secret(value string) string {
+	if unsafe() || value == "" {
+		return value
+	}
+	return REDACTED
+}
+
+// JWT keeps the header of a token, whose alg and kid are needed to debug signature
+// failures, but masks the claims, which may hold personal data, and the signature, so
+// the token can neither be read nor replayed.
+func JWT(token string) string {
+	if unsafe() {
+		return token
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Secret(token)
+	}
+	return parts[0] + "." + REDACTED + "." + REDACTED
+}
+
+// BootstrapToken keeps the ID of a <token-id>.<token-secret> bootstrap token and
+// masks the secret.
+func BootstrapToken(token string) string {
+	if unsafe() {
+		return token
+	}
+
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return Secret(token)
+	}
+	return parts[0] + "." + REDACTED
+}
+
+// Map returns a copy of the map with the values of sensitive keys masked.
+func Map(values map[string]string) map[string]string {
+	if values == nil || unsafe() {
+		return values
+	}
+
+	redacted := make(map[string]string, len(values))
+	for key, value := range values {
+		if isSensitive(key) {
+			value = Secret(value)
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// JSON masks the values of sensitive keys anywhere in a JSON document. Documents
+// which cannot be parsed are masked entirely.
+func JSON(data []byte) string {
+	if unsafe() {
+		return string(data)
+	}
+
+	var document interface{}
+	err := json.Unmarshal(data, &document)
+	if err != nil {
+		return Secret(string(data))
+	}
+
+	redacted, err := json.Marshal(redactValue(document))
+	if err != nil {
+		return Secret(string(data))
+	}
+	return string(redacted)
+}
+
+func redactValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range typed {
+			if isSensitive(key) {
+				if nestedString, ok := nested.(string); ok && nestedString == "" {
+					continue
+				}
+				typed[key] = REDACTED
+				continue
+			}
+			typed[key] = redactValue(nested)
+		}
+	case []interface{}:
+		for i, nested := range typed {
+			typed[i] = redactValue(nested)
+		}
+	}
+	return value
+}
+
+func isSensitive(key string) bool {
+	for _, sensitiveKey := range sensitiveKeys {
+		if strings.EqualFold(key, sensitiveKey) {
+			return true
+		}
+	}
+	return false
+}
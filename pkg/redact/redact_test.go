@@ -0,0 +1,174 @@
+package redact
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// withUnsafeSecrets enables unsafe secrets for the rest of the test.
+func withUnsafeSecrets(t *testing.T) {
+	SetUnsafeSecrets(true)
+	t.Cleanup(func() { SetUnsafeSecrets(false) })
+}
+
+func TestJWT(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		expected string
+	}{
+		{"signed token", "header.payload.signature", "header.REDACTED.REDACTED"},
+		{"unsigned token", "header.payload.", "header.REDACTED.REDACTED"},
+		{"too few parts", "header.payload", REDACTED},
+		{"too many parts", "a.b.c.d", REDACTED},
+		{"not a token", "secret", REDACTED},
+		{"empty", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := JWT(test.token); got != test.expected {
+				t.Errorf("JWT(%q) = %q, expected %q", test.token, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestBootstrapToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		expected string
+	}{
+		{"token", "abcdef.0123456789abcdef", "abcdef.REDACTED"},
+		{"empty secret", "abcdef.", "abcdef.REDACTED"},
+		{"no separator", "abcdef0123456789abcdef", REDACTED},
+		{"empty", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := BootstrapToken(test.token); got != test.expected {
+				t.Errorf("BootstrapToken(%q) = %q, expected %q", test.token, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "sensitive keys",
+			values:   map[string]string{"token-id": "abcdef", "token-secret": "0123456789abcdef", "Password": "hunter2"},
+			expected: map[string]string{"token-id": "abcdef", "token-secret": REDACTED, "Password": REDACTED},
+		},
+		{
+			name:     "empty values",
+			values:   map[string]string{"token-secret": "", "description": ""},
+			expected: map[string]string{"token-secret": "", "description": ""},
+		},
+		{
+			name:     "nil",
+			values:   nil,
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := make(map[string]string, len(test.values))
+			for key, value := range test.values {
+				original[key] = value
+			}
+
+			if got := Map(test.values); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("Map(%v) = %v, expected %v", test.values, got, test.expected)
+			}
+			if test.values != nil && !reflect.DeepEqual(test.values, original) {
+				t.Errorf("expected the input map not to be modified, got %v", test.values)
+			}
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{
+			name:     "top level keys",
+			data:     `{"access_token":"token","expires_in":"3599"}`,
+			expected: `{"access_token":"REDACTED","expires_in":"3599"}`,
+		},
+		{
+			name:     "nested keys",
+			data:     `{"compute":{"name":"vm","osProfile":{"customData":"data","adminUsername":"azureuser"}}}`,
+			expected: `{"compute":{"name":"vm","osProfile":{"adminUsername":"azureuser","customData":"REDACTED"}}}`,
+		},
+		{
+			name:     "arrays",
+			data:     `[{"password":"secret"},{"clientSecret":{"value":"secret"}},"plain"]`,
+			expected: `[{"password":"REDACTED"},{"clientSecret":"REDACTED"},"plain"]`,
+		},
+		{
+			name:     "empty values",
+			data:     `{"password":"","userData":null}`,
+			expected: `{"password":"","userData":"REDACTED"}`,
+		},
+		{
+			name:     "not json",
+			data:     `access_token=token`,
+			expected: REDACTED,
+		},
+		{
+			name:     "empty",
+			data:     ``,
+			expected: ``,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := JSON([]byte(test.data))
+			if got != test.expected {
+				t.Errorf("JSON(%s) = %s, expected %s", test.data, got, test.expected)
+			}
+			if got != REDACTED && got != "" && !json.Valid([]byte(got)) {
+				t.Errorf("expected valid JSON, got %s", got)
+			}
+		})
+	}
+}
+
+func TestUnsafeSecrets(t *testing.T) {
+	withUnsafeSecrets(t)
+
+	if got := Secret("secret"); got != "secret" {
+		t.Errorf("Secret = %q, expected the value in full", got)
+	}
+	if got := JWT("header.payload.signature"); got != "header.payload.signature" {
+		t.Errorf("JWT = %q, expected the token in full", got)
+	}
+	if got := BootstrapToken("abcdef.0123456789abcdef"); got != "abcdef.0123456789abcdef" {
+		t.Errorf("BootstrapToken = %q, expected the token in full", got)
+	}
+	values := map[string]string{"token-secret": "0123456789abcdef"}
+	if got := Map(values); !reflect.DeepEqual(got, values) {
+		t.Errorf("Map = %v, expected the values in full", got)
+	}
+	if got := JSON([]byte(`{"password":"secret"}`)); got != `{"password":"secret"}` {
+		t.Errorf("JSON = %s, expected the document in full", got)
+	}
+
+	SetUnsafeSecrets(false)
+	if got := Secret("secret"); got != REDACTED {
+		t.Errorf("Secret = %q after disabling unsafe secrets, expected %s", got, REDACTED)
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ALLOWED_IDENTITIES_KIND_CONFIGMAP = "ConfigMap"
+	ALLOWED_IDENTITIES_KIND_SECRET    = "Secret"
+
+	// ALLOWED_IDENTITIES_KEY is the key in the ConfigMap or Secret holding the
+	// YAML list of allowed identities.
+	ALLOWED_IDENTITIES_KEY = "identities.yaml"
+
+	allowedIdentitiesSyncTimeout = 30 * time.Second
+)
+
+// AllowedIdentity is an Azure AD object ID allowed to request bootstrap tokens.
+type AllowedIdentity struct {
+	Id          string     `json:"id"`
+	Description string     `json:"description,omitempty"`
+	Expiry      *time.Time `json:"expiry,omitempty"`
+}
+
+// identityAllowlist is an immutable set of allowed identities keyed by lower-case object ID.
+type identityAllowlist map[string]*AllowedIdentity
+
+// parseAllowedIdentities parses the YAML list of allowed identities.
+func parseAllowedIdentities(data []byte) (identityAllowlist, error) {
+	identities := []*AllowedIdentity{}
+	err := yaml.UnmarshalStrict(data, &identities)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse allowed identities: %v", err)
+	}
+
+	allowlist := identityAllowlist{}
+	for i, identity := range identities {
+		if identity == nil || identity.Id == "" {
+			return nil, fmt.Errorf("allowed identity %d has no id", i)
+		}
+		allowlist[strings.ToLower(identity.Id)] = identity
+	}
+
+	return allowlist, nil
+}
+
+// isAllowedIdentity returns the allowlist entry for the object ID if it is allowed,
+// either statically via AllowedClientIds or by an unexpired entry in the watched allowlist.
+func (s *TlsBootstrapServer) isAllowedIdentity(oid string, now time.Time) (*AllowedIdentity, bool) {
+	for _, id := range s.AllowedClientIds {
+		if id != "" && strings.EqualFold(oid, id) {
+			return &AllowedIdentity{Id: id, Description: "allowed by -allowed-client-ids"}, true
+		}
+	}
+
+	allowlist, _ := s.allowedIdentities.Load().(identityAllowlist)
+	identity, ok := allowlist[strings.ToLower(oid)]
+	if !ok {
+		return nil, false
+	}
+	if identity.Expiry != nil && identity.Expiry.Before(now) {
+		return identity, false
+	}
+
+	return identity, true
+}
+
+// watchAllowedIdentities starts an informer on the configured ConfigMap or Secret and
+// atomically replaces the allowlist whenever it changes.
+func (s *TlsBootstrapServer) watchAllowedIdentities() error {
+	if s.AllowedIdentitiesName == "" {
+		return nil
+	}
+
+	namespace := s.AllowedIdentitiesNamespace
+	if namespace == "" {
+		namespace = "kube-system"
+	}
+	watchLog := s.Log.WithFields(logrus.Fields{
+		"kind":      s.AllowedIdentitiesKind,
+		"namespace": namespace,
+		"name":      s.AllowedIdentitiesName,
+	})
+
+	factory := informers.NewSharedInformerFactoryWithOptions(s.k8sClientSet, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metaV1.ListOptions) {
+			options.FieldSelector = "metadata.name=" + s.AllowedIdentitiesName
+		}),
+	)
+
+	var informer cache.SharedIndexInformer
+	switch s.AllowedIdentitiesKind {
+	case "", ALLOWED_IDENTITIES_KIND_CONFIGMAP:
+		informer = factory.Core().V1().ConfigMaps().Informer()
+	case ALLOWED_IDENTITIES_KIND_SECRET:
+		informer = factory.Core().V1().Secrets().Informer()
+	default:
+		return fmt.Errorf("unknown allowed identities kind %q, expected %s or %s", s.AllowedIdentitiesKind, ALLOWED_IDENTITIES_KIND_CONFIGMAP, ALLOWED_IDENTITIES_KIND_SECRET)
+	}
+
+	update := func(obj interface{}) {
+		var data []byte
+		switch object := obj.(type) {
+		case *coreV1.ConfigMap:
+			data = []byte(object.Data[ALLOWED_IDENTITIES_KEY])
+		case *coreV1.Secret:
+			data = object.Data[ALLOWED_IDENTITIES_KEY]
+		default:
+			return
+		}
+
+		allowlist, err := parseAllowedIdentities(data)
+		if err != nil {
+			watchLog.WithError(err).Error("failed to update allowed identities, keeping previous allowlist")
+			return
+		}
+		s.allowedIdentities.Store(allowlist)
+		watchLog.Infof("loaded %d allowed identities", len(allowlist))
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: update,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			update(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			s.allowedIdentities.Store(identityAllowlist{})
+			watchLog.Warn("allowed identities object was deleted, clearing allowlist")
+		},
+	})
+
+	// the informer runs for the lifetime of the server
+	factory.Start(make(chan struct{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), allowedIdentitiesSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("timed out waiting for allowed identities %s/%s to sync", namespace, s.AllowedIdentitiesName)
+	}
+
+	watchLog.Info("watching allowed identities")
+	return nil
+}
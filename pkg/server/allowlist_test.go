@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseAllowedIdentities(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		expected  []string
+		expectErr bool
+	}{
+		{
+			name: "identities",
+			data: `
+- id: 44444444-4444-4444-4444-444444444444
+  description: node pool identity
+- id: AAAAAAAA-4444-4444-4444-444444444444
+  expiry: 2030-01-01T00:00:00Z
+`,
+			expected: []string{testCallerOid, "aaaaaaaa-4444-4444-4444-444444444444"},
+		},
+		{
+			name:     "empty",
+			data:     ``,
+			expected: []string{},
+		},
+		{
+			name:      "unknown field",
+			data:      "- id: " + testCallerOid + "\n  expires: 2030-01-01T00:00:00Z\n",
+			expectErr: true,
+		},
+		{
+			name:      "missing id",
+			data:      "- description: node pool identity\n",
+			expectErr: true,
+		},
+		{
+			name:      "invalid expiry",
+			data:      "- id: " + testCallerOid + "\n  expiry: tomorrow\n",
+			expectErr: true,
+		},
+		{
+			name:      "not a list",
+			data:      "id: " + testCallerOid + "\n",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allowlist, err := parseAllowedIdentities([]byte(test.data))
+			if test.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", allowlist)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(allowlist) != len(test.expected) {
+				t.Fatalf("expected %d identities, got %v", len(test.expected), allowlist)
+			}
+			for _, id := range test.expected {
+				if _, ok := allowlist[id]; !ok {
+					t.Errorf("expected identity %s in %v", id, allowlist)
+				}
+			}
+		})
+	}
+}
+
+func TestIsAllowedIdentity(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	s := &TlsBootstrapServer{AllowedClientIds: []string{"55555555-5555-5555-5555-55555555555a"}}
+	s.allowedIdentities.Store(identityAllowlist{
+		"expired":   {Id: "Expired", Expiry: &past},
+		"future":    {Id: "Future", Expiry: &future},
+		"no-expiry": {Id: "No-Expiry"},
+	})
+
+	tests := []struct {
+		name          string
+		oid           string
+		allowed       bool
+		expectedEntry bool
+	}{
+		{name: "expired entry", oid: "expired", expectedEntry: true},
+		{name: "entry expiring in the future", oid: "future", allowed: true, expectedEntry: true},
+		{name: "entry without expiry", oid: "no-expiry", allowed: true, expectedEntry: true},
+		{name: "entry in different case", oid: "NO-EXPIRY", allowed: true, expectedEntry: true},
+		{name: "static client id in different case", oid: "55555555-5555-5555-5555-55555555555A", allowed: true, expectedEntry: true},
+		{name: "unknown identity", oid: testCallerOid},
+		{name: "empty oid", oid: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, allowed := s.isAllowedIdentity(test.oid, now)
+			if allowed != test.allowed {
+				t.Errorf("expected allowed %v, got %v", test.allowed, allowed)
+			}
+			if (identity != nil) != test.expectedEntry {
+				t.Errorf("expected entry %v, got %+v", test.expectedEntry, identity)
+			}
+		})
+	}
+
+	if _, allowed := (&TlsBootstrapServer{}).isAllowedIdentity(testCallerOid, now); allowed {
+		t.Errorf("expected a server without allowlist to allow nothing")
+	}
+}
+
+func TestWatchAllowedIdentities(t *testing.T) {
+	const name = "tls-bootstrap-allowed-identities"
+	clientset := fake.NewSimpleClientset(&coreV1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{Name: name, Namespace: metaV1.NamespaceSystem},
+		Data:       map[string]string{ALLOWED_IDENTITIES_KEY: "- id: " + testCallerOid + "\n"},
+	})
+	configMaps := clientset.CoreV1().ConfigMaps(metaV1.NamespaceSystem)
+	s := &TlsBootstrapServer{
+		Log:                   newTestLog(),
+		AllowedIdentitiesKind: ALLOWED_IDENTITIES_KIND_CONFIGMAP,
+		AllowedIdentitiesName: name,
+		k8sClientSet:          clientset,
+	}
+
+	if err := s.watchAllowedIdentities(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, allowed := s.isAllowedIdentity(testCallerOid, time.Now()); !allowed {
+		t.Fatalf("expected the initial allowlist to be loaded")
+	}
+
+	waitForAllowed := func(oid string, expected bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			if _, allowed := s.isAllowedIdentity(oid, time.Now()); allowed == expected {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s to be allowed %v", oid, expected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// an invalid update keeps the previous allowlist
+	_, err := configMaps.Update(context.Background(), &coreV1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{Name: name, Namespace: metaV1.NamespaceSystem},
+		Data:       map[string]string{ALLOWED_IDENTITIES_KEY: "- id: " + testTenantId + "\n  unknown: true\n"},
+	}, metaV1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error updating configmap: %v", err)
+	}
+	_, err = configMaps.Update(context.Background(), &coreV1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{Name: name, Namespace: metaV1.NamespaceSystem},
+		Data:       map[string]string{ALLOWED_IDENTITIES_KEY: "- id: " + testCallerOid + "\n- id: " + testTenantId + "\n"},
+	}, metaV1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error updating configmap: %v", err)
+	}
+	waitForAllowed(testTenantId, true)
+	waitForAllowed(testCallerOid, true)
+
+	if err := configMaps.Delete(context.Background(), name, metaV1.DeleteOptions{}); err != nil {
+		t.Fatalf("unexpected error deleting configmap: %v", err)
+	}
+	waitForAllowed(testCallerOid, false)
+	waitForAllowed(testTenantId, false)
+}
@@ -11,59 +11,86 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"go.mozilla.org/pkcs7"
+	"google.golang.org/grpc/codes"
 )
 
 func (s *TlsBootstrapServer) validateAttestedData(signedAttestedData string, signerHostName string) (*AttestedData, error) {
 	decodedSignature, err := base64.StdEncoding.DecodeString(signedAttestedData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode base64 signature: %v", err)
+		return nil, newError(codes.PermissionDenied, REASON_ATTESTED_DATA_INVALID, "failed to decode base64 signature: %v", err)
 	}
 
 	p7, err := pkcs7.Parse(decodedSignature)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse pkcs7 signature block: %v", err)
+		return nil, newError(codes.PermissionDenied, REASON_ATTESTED_DATA_INVALID, "failed to parse pkcs7 signature block: %v", err)
 	}
 
 	pkcs7SignerCertificate := p7.GetOnlySigner()
+	if pkcs7SignerCertificate == nil {
+		return nil, newError(codes.PermissionDenied, REASON_ATTESTED_DATA_INVALID, "pkcs7 signature block does not have exactly one signer")
+	}
 	s.Log.WithFields(logrus.Fields{
 		"subject": pkcs7SignerCertificate.Subject,
 		"issuer":  pkcs7SignerCertificate.Issuer,
 	}).Debug("pkcs7 signature parsed")
 
-	intermediateCertCached := false
-	for _, cachedSubject := range s.intermediateCertPool.Subjects() {
-		if bytes.Compare(cachedSubject, pkcs7SignerCertificate.RawIssuer) == 0 {
-			s.Log.Debug("intermediate certificate already cached")
-			intermediateCertCached = true
-		}
+	// The signer certificate is only meaningful if it actually signed the content;
+	// without this check the nonce and VM identity fields could be forged.
+	err = p7.Verify()
+	if err != nil {
+		return nil, newError(codes.PermissionDenied, REASON_ATTESTED_DATA_INVALID, "failed to verify pkcs7 signature: %v", err)
 	}
 
-	if !intermediateCertCached {
+	if !s.isIntermediateCertificateCached(pkcs7SignerCertificate.RawIssuer) {
+		if len(pkcs7SignerCertificate.IssuingCertificateURL) == 0 {
+			return nil, newError(codes.PermissionDenied, REASON_ATTESTED_DATA_INVALID, "signer certificate issuer is not cached and the certificate has no issuing certificate URL")
+		}
 		intermediateCert, err := s.getIntermediateCertificate(pkcs7SignerCertificate.IssuingCertificateURL[0])
 		if err != nil {
-			return nil, fmt.Errorf("failed to retrieve intermediate certificate: %v", err)
+			err = newError(codes.Unavailable, REASON_INTERMEDIATE_UNAVAILABLE, "failed to retrieve intermediate certificate: %v", err)
+			intermediateCertificateFetches.WithLabelValues(resultLabel(err)).Inc()
+			return nil, err
 		}
+		intermediateCertificateFetches.WithLabelValues(RESULT_SUCCESS).Inc()
+		s.intermediateCertLock.Lock()
 		s.intermediateCertPool.AddCert(intermediateCert)
+		s.intermediateCertLock.Unlock()
 	}
 
+	// the pool is shared between requests, so hold the read lock while the chain is built
+	s.intermediateCertLock.RLock()
 	_, err = pkcs7SignerCertificate.Verify(x509.VerifyOptions{
 		DNSName:       signerHostName,
 		Intermediates: s.intermediateCertPool,
 		Roots:         nil,
 	})
+	s.intermediateCertLock.RUnlock()
 	if err != nil {
-		return nil, fmt.Errorf("failed to verify %s hostname: %v", signerHostName, err)
+		return nil, newError(codes.PermissionDenied, REASON_ATTESTED_DATA_INVALID, "failed to verify signer certificate chain for %s: %v", signerHostName, err)
 	}
 
 	attestedData := &AttestedData{}
 	err = json.Unmarshal(p7.Content, attestedData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal attested data: %v", err)
+		return nil, newError(codes.PermissionDenied, REASON_ATTESTED_DATA_INVALID, "failed to unmarshal attested data: %v", err)
 	}
 
 	return attestedData, nil
 }
 
+func (s *TlsBootstrapServer) isIntermediateCertificateCached(rawIssuer []byte) bool {
+	s.intermediateCertLock.RLock()
+	defer s.intermediateCertLock.RUnlock()
+
+	for _, cachedSubject := range s.intermediateCertPool.Subjects() {
+		if bytes.Equal(cachedSubject, rawIssuer) {
+			s.Log.Debug("intermediate certificate already cached")
+			return true
+		}
+	}
+	return false
+}
+
 func (s *TlsBootstrapServer) getIntermediateCertificate(url string) (*x509.Certificate, error) {
 	client := http.Client{}
 	s.Log.WithField("url", url).Infof("retrieving intermediate certificate")
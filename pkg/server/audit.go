@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	AUDIT_OUTCOME_ALLOWED = "allowed"
+	AUDIT_OUTCOME_DENIED  = "denied"
+)
+
+const auditEventContextKey contextKey = "auditEvent"
+
+// AuditEvent records the decision made for a single GetNonce or GetToken request,
+// including requests rejected during authentication. It never holds the bootstrap
+// token secret.
+type AuditEvent struct {
+	Time        time.Time `json:"time"`
+	Method      string    `json:"method"`
+	CallerOid   string    `json:"callerOid,omitempty"`
+	CallerTid   string    `json:"callerTid,omitempty"`
+	CallerAppId string    `json:"callerAppId,omitempty"`
+	ResourceId  string    `json:"resourceId,omitempty"`
+	VmId        string    `json:"vmId,omitempty"`
+	VmName      string    `json:"vmName,omitempty"`
+	NodePool    string    `json:"nodePool,omitempty"`
+	Outcome     string    `json:"outcome"`
+	Reason      string    `json:"reason,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	TokenId     string    `json:"tokenId,omitempty"`
+}
+
+// AuditSink receives one AuditEvent per request decision. Implementations must be
+// safe for concurrent use.
+type AuditSink interface {
+	Record(event *AuditEvent) error
+}
+
+type jsonAuditSink struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONAuditSink writes each event as a line of JSON to the given writer.
+func NewJSONAuditSink(writer io.Writer) AuditSink {
+	return &jsonAuditSink{
+		encoder: json.NewEncoder(writer),
+	}
+}
+
+// NewFileAuditSink appends events as JSON lines to the file at the given path,
+// or writes them to stdout if the path is "-".
+func NewFileAuditSink(path string) (AuditSink, error) {
+	if path == "-" {
+		return NewJSONAuditSink(os.Stdout), nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %v", path, err)
+	}
+
+	return NewJSONAuditSink(file), nil
+}
+
+func (j *jsonAuditSink) Record(event *AuditEvent) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.encoder.Encode(event)
+}
+
+type multiAuditSink []AuditSink
+
+// NewMultiAuditSink records each event to all of the given sinks, skipping nil ones.
+func NewMultiAuditSink(sinks ...AuditSink) AuditSink {
+	multi := multiAuditSink{}
+	for _, sink := range sinks {
+		if sink != nil {
+			multi = append(multi, sink)
+		}
+	}
+	return multi
+}
+
+func (m multiAuditSink) Record(event *AuditEvent) error {
+	errs := []error{}
+	for _, sink := range m {
+		err := sink.Record(event)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to record audit event to %d sink(s): %v", len(errs), errs)
+	}
+	return nil
+}
+
+// UnaryAuditInterceptor records one AuditEvent for every unary call except those to
+// authExemptServices. It must be installed ahead of the auth interceptor so that
+// calls rejected during authentication are recorded too; handlers add detail to
+// the event through auditEventFromContext.
+func (s *TlsBootstrapServer) UnaryAuditInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isAuthExempt(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		event := &AuditEvent{
+			Method: path.Base(info.FullMethod),
+		}
+		if resourceRequest, ok := req.(interface{ GetResourceId() string }); ok {
+			event.ResourceId = resourceRequest.GetResourceId()
+		}
+
+		response, err := handler(context.WithValue(ctx, auditEventContextKey, event), req)
+		s.audit(event, err)
+		return response, err
+	}
+}
+
+// auditEventFromContext returns the event being recorded for the current call, or a
+// detached event if the call did not pass through UnaryAuditInterceptor.
+func auditEventFromContext(ctx context.Context) *AuditEvent {
+	event, ok := ctx.Value(auditEventContextKey).(*AuditEvent)
+	if !ok || event == nil {
+		return &AuditEvent{}
+	}
+	return event
+}
+
+// setCaller records the authenticated identity of the caller.
+func (event *AuditEvent) setCaller(tokenClaims *AzureADTokenClaims) {
+	event.CallerOid = tokenClaims.Oid
+	event.CallerTid = tokenClaims.Tid
+	event.CallerAppId = tokenClaims.AppId
+	if event.CallerAppId == "" {
+		event.CallerAppId = tokenClaims.Azp
+	}
+}
+
+// audit records the outcome of a request. Failing to record an event is logged but
+// does not fail the request.
+func (s *TlsBootstrapServer) audit(event *AuditEvent, err error) {
+	if s.AuditSink == nil {
+		return
+	}
+
+	event.Time = time.Now().UTC()
+	if err == nil {
+		event.Outcome = AUDIT_OUTCOME_ALLOWED
+	} else {
+		event.Outcome = AUDIT_OUTCOME_DENIED
+		event.Reason = resultLabel(err)
+		event.Message = err.Error()
+	}
+
+	recordErr := s.AuditSink.Record(event)
+	if recordErr != nil {
+		s.Log.WithError(recordErr).Error("failed to record audit event")
+	}
+}
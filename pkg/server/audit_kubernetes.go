@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coreV1Types "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	auditEventComponent    = "aks-tls-bootstrap-server"
+	auditEventReasonIssued = "BootstrapTokenIssued"
+	auditEventReasonDenied = "BootstrapTokenDenied"
+	auditMethodGetToken    = "GetToken"
+)
+
+// kubernetesEventAuditSink records GetToken audit events as Kubernetes Events against the
+// Node the VM will join as, or the kube-system namespace if the VM is not yet known.
+type kubernetesEventAuditSink struct {
+	eventsClient coreV1Types.EventInterface
+	namespace    string
+}
+
+// NewKubernetesEventAuditSink creates Events through the given client, which must be
+// scoped to the namespace named. Events about cluster-scoped objects such as Nodes
+// are only accepted in the default namespace.
+func NewKubernetesEventAuditSink(eventsClient coreV1Types.EventInterface, namespace string) AuditSink {
+	return &kubernetesEventAuditSink{
+		eventsClient: eventsClient,
+		namespace:    namespace,
+	}
+}
+
+func (k *kubernetesEventAuditSink) Record(event *AuditEvent) error {
+	// nonces are requested on every bootstrap attempt and say nothing about a
+	// node, so only token decisions are worth surfacing as events.
+	if event.Method != auditMethodGetToken {
+		return nil
+	}
+
+	involvedObject := coreV1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       "kube-system",
+	}
+	if event.VmName != "" {
+		involvedObject = coreV1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       strings.ToLower(event.VmName),
+		}
+	}
+
+	eventType := coreV1.EventTypeNormal
+	reason := auditEventReasonIssued
+	message := fmt.Sprintf("issued bootstrap token %s to %s (vmId %s, node pool %q) for caller %s", event.TokenId, event.ResourceId, event.VmId, event.NodePool, event.CallerOid)
+	if event.Outcome != AUDIT_OUTCOME_ALLOWED {
+		eventType = coreV1.EventTypeWarning
+		reason = auditEventReasonDenied
+		message = fmt.Sprintf("denied bootstrap token for %s (vmId %s) to caller %s: %s: %s", event.ResourceId, event.VmId, event.CallerOid, event.Reason, event.Message)
+	}
+
+	timestamp := metaV1.NewTime(event.Time)
+	_, err := k.eventsClient.Create(context.Background(), &coreV1.Event{
+		ObjectMeta: metaV1.ObjectMeta{
+			GenerateName: "tls-bootstrap.",
+			Namespace:    k.namespace,
+		},
+		InvolvedObject: involvedObject,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source: coreV1.EventSource{
+			Component: auditEventComponent,
+		},
+		FirstTimestamp: timestamp,
+		LastTimestamp:  timestamp,
+		Count:          1,
+	}, metaV1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create audit event: %v", err)
+	}
+
+	return nil
+}
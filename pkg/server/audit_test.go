@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/aks-tls-bootstrap/pkg/azure"
+	pb "github.com/Azure/aks-tls-bootstrap/pkg/proto"
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+type recordingAuditSink struct {
+	lock   sync.Mutex
+	events []AuditEvent
+}
+
+func (r *recordingAuditSink) Record(event *AuditEvent) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	key := generateTestKey(t)
+	sink := &recordingAuditSink{}
+	s := &TlsBootstrapServer{
+		Log:                      newTestLog(),
+		TenantId:                 testTenantId,
+		Cloud:                    azure.AzurePublic,
+		Audience:                 testAudience,
+		AllowedSigningAlgorithms: []string{"RS256"},
+		AllowedClientIds:         []string{testCallerOid},
+		AuditSink:                sink,
+		jwks:                     newTestJWKS(t, key),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"aud":   testAudience,
+		"iss":   azure.AzurePublic.ActiveDirectoryAuthorityHost + testTenantId + "/v2.0",
+		"tid":   testTenantId,
+		"oid":   testCallerOid,
+		"appid": "test-app",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = testKeyId
+	signedToken, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	auditInterceptor := s.UnaryAuditInterceptor()
+	authInterceptor := s.UnaryAuthInterceptor()
+	call := func(ctx context.Context, fullMethod string, req interface{}, handler grpc.UnaryHandler) error {
+		info := &grpc.UnaryServerInfo{FullMethod: fullMethod}
+		_, err := auditInterceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return authInterceptor(ctx, req, info, handler)
+		})
+		return err
+	}
+	withToken := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "bearer "+signedToken))
+	succeed := func(ctx context.Context, req interface{}) (interface{}, error) {
+		auditEventFromContext(ctx).TokenId = "abcdef"
+		return &pb.TokenResponse{}, nil
+	}
+	deny := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, newError(codes.PermissionDenied, REASON_ADMISSION_DENIED, "denied")
+	}
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		fullMethod string
+		req        interface{}
+		handler    grpc.UnaryHandler
+		expected   *AuditEvent
+	}{
+		{
+			name:       "missing token on GetToken",
+			ctx:        context.Background(),
+			fullMethod: "/aks_tls_bootstrap.AKSBootstrapTokenRequest/GetToken",
+			req:        &pb.TokenRequest{ResourceId: testVmResourceId},
+			handler:    succeed,
+			expected:   &AuditEvent{Method: "GetToken", ResourceId: testVmResourceId, Outcome: AUDIT_OUTCOME_DENIED, Reason: REASON_MISSING_TOKEN},
+		},
+		{
+			name:       "missing token on GetNonce",
+			ctx:        context.Background(),
+			fullMethod: "/aks_tls_bootstrap.AKSBootstrapTokenRequest/GetNonce",
+			req:        &pb.NonceRequest{ResourceId: testVmResourceId},
+			handler:    succeed,
+			expected:   &AuditEvent{Method: "GetNonce", ResourceId: testVmResourceId, Outcome: AUDIT_OUTCOME_DENIED, Reason: REASON_MISSING_TOKEN},
+		},
+		{
+			name:       "allowed GetToken",
+			ctx:        withToken,
+			fullMethod: "/aks_tls_bootstrap.AKSBootstrapTokenRequest/GetToken",
+			req:        &pb.TokenRequest{ResourceId: testVmResourceId},
+			handler:    succeed,
+			expected:   &AuditEvent{Method: "GetToken", ResourceId: testVmResourceId, CallerOid: testCallerOid, CallerTid: testTenantId, CallerAppId: "test-app", Outcome: AUDIT_OUTCOME_ALLOWED, TokenId: "abcdef"},
+		},
+		{
+			name:       "denied by handler",
+			ctx:        withToken,
+			fullMethod: "/aks_tls_bootstrap.AKSBootstrapTokenRequest/GetToken",
+			req:        &pb.TokenRequest{ResourceId: testVmResourceId},
+			handler:    deny,
+			expected:   &AuditEvent{Method: "GetToken", ResourceId: testVmResourceId, CallerOid: testCallerOid, CallerTid: testTenantId, CallerAppId: "test-app", Outcome: AUDIT_OUTCOME_DENIED, Reason: REASON_ADMISSION_DENIED},
+		},
+		{
+			name:       "auth exempt method",
+			ctx:        context.Background(),
+			fullMethod: "/grpc.health.v1.Health/Check",
+			handler:    succeed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink.events = nil
+			call(test.ctx, test.fullMethod, test.req, test.handler)
+
+			if test.expected == nil {
+				if len(sink.events) != 0 {
+					t.Fatalf("expected no audit events, got %+v", sink.events)
+				}
+				return
+			}
+			if len(sink.events) != 1 {
+				t.Fatalf("expected exactly one audit event, got %+v", sink.events)
+			}
+
+			event := sink.events[0]
+			if event.Time.IsZero() {
+				t.Errorf("expected event time to be set")
+			}
+			if test.expected.Outcome == AUDIT_OUTCOME_DENIED && event.Message == "" {
+				t.Errorf("expected denied event to have a message")
+			}
+			event.Time = time.Time{}
+			event.Message = ""
+			if event != *test.expected {
+				t.Errorf("expected %+v, got %+v", *test.expected, event)
+			}
+		})
+	}
+}
@@ -3,12 +3,78 @@ package server
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/Azure/aks-tls-bootstrap/pkg/redact"
 	"github.com/golang-jwt/jwt/v4"
 	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+type contextKey string
+
+const tokenClaimsContextKey contextKey = "tokenClaims"
+
+// authExemptServices can be called without a token so that load balancers and
+// probes can check health and tooling can use reflection.
+var authExemptServices = []string{
+	"/grpc.health.v1.Health/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+	"/grpc.reflection.v1.ServerReflection/",
+}
+
+func isAuthExempt(fullMethod string) bool {
+	for _, service := range authExemptServices {
+		if strings.HasPrefix(fullMethod, service) {
+			return true
+		}
+	}
+	return false
+}
+
+// authFunc authenticates the caller and records the outcome of the JWT stage.
+func (s *TlsBootstrapServer) authFunc(ctx context.Context) (context.Context, error) {
+	newCtx, err := s.authenticate(ctx)
+	observeStage(STAGE_JWT, err)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenClaims, err := tokenClaimsFromContext(newCtx)
+	if err == nil {
+		auditEventFromContext(newCtx).setCaller(tokenClaims)
+	}
+	return newCtx, nil
+}
+
+// UnaryAuthInterceptor validates the caller's token for every unary call except
+// those to authExemptServices.
+func (s *TlsBootstrapServer) UnaryAuthInterceptor() grpc.UnaryServerInterceptor {
+	authInterceptor := grpc_auth.UnaryServerInterceptor(s.authFunc)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isAuthExempt(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		return authInterceptor(ctx, req, info, handler)
+	}
+}
+
+// StreamAuthInterceptor validates the caller's token for every streaming call except
+// those to authExemptServices.
+func (s *TlsBootstrapServer) StreamAuthInterceptor() grpc.StreamServerInterceptor {
+	authInterceptor := grpc_auth.StreamServerInterceptor(s.authFunc)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isAuthExempt(info.FullMethod) {
+			return handler(srv, stream)
+		}
+		return authInterceptor(srv, stream, info, handler)
+	}
+}
+
 func AuthFunction(ctx context.Context) (context.Context, error) {
 	fmt.Printf("ctx: %v\n", ctx)
 
@@ -19,52 +85,126 @@ func (s *TlsBootstrapServer) ValidateToken(ctx context.Context) (context.Context
 	s.Log.Infof("validating token")
 	tokenString, err := grpc_auth.AuthFromMD(ctx, "bearer")
 	if err != nil {
+		err = newError(codes.Unauthenticated, REASON_MISSING_TOKEN, "%s", status.Convert(err).Message())
 		s.Log.Error(err)
 		return nil, err
 	}
 	if tokenString == "" {
-		err = fmt.Errorf("no token supplied")
+		err = newError(codes.Unauthenticated, REASON_MISSING_TOKEN, "no token supplied")
 		s.Log.Error(err)
 		return nil, err
 	}
 
-	s.Log.WithField("token", tokenString).Debug("attempting to validate JWT")
+	s.Log.WithField("token", redact.JWT(tokenString)).Debug("attempting to validate JWT")
 	tokenClaims := &AzureADTokenClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, tokenClaims, jwks.Keyfunc)
+	// claims are validated below with the configured clock skew, so only the
+	// signature and algorithm are checked by the parser.
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(s.AllowedSigningAlgorithms),
+		jwt.WithoutClaimsValidation(),
+	)
+	token, err := parser.ParseWithClaims(tokenString, tokenClaims, s.jwks.Keyfunc)
 	if err != nil {
-		err = fmt.Errorf("failed to parse token: %v", err)
+		err = newError(codes.Unauthenticated, REASON_INVALID_TOKEN, "failed to parse token: %v", err)
+		s.Log.Error(err)
+		return nil, err
+	}
+	if token == nil || !token.Valid {
+		err = newError(codes.Unauthenticated, REASON_INVALID_TOKEN, "token signature is not valid")
+		s.Log.Error(err)
+		return nil, err
 	}
 	authLog := s.Log.WithFields(logrus.Fields{
 		"oid": tokenClaims.Oid,
 		"tid": tokenClaims.Tid,
 	})
 
-	err = token.Claims.Valid()
+	err = s.validateTokenClaims(tokenClaims, time.Now())
 	if err != nil {
-		err = fmt.Errorf("token claims are not valid: %v", err)
+		err = newError(codes.Unauthenticated, REASON_INVALID_TOKEN, "token claims are not valid: %v", err)
 		authLog.Error(err)
 		return nil, err
 	}
 
 	if tokenClaims.Tid != s.TenantId {
-		err = fmt.Errorf("token tenant ID %s does not match expected tenant ID %s", tokenClaims.Tid, s.TenantId)
+		err = newError(codes.Unauthenticated, REASON_TENANT_MISMATCH, "token tenant ID %s does not match expected tenant ID %s", tokenClaims.Tid, s.TenantId)
 		authLog.Error(err)
 		return nil, err
 	}
 
-	allowedId := false
-	for _, id := range s.AllowedClientIds {
-		if tokenClaims.Oid == id {
-			allowedId = true
-		}
-	}
-	if !allowedId {
-		err = fmt.Errorf("principal ID %s is not in allowed ID list", tokenClaims.Oid)
+	allowedBy, err := s.authorizeCaller(tokenClaims, authLog)
+	if err != nil {
 		authLog.Error(err)
 		return nil, err
 	}
+	authLog = authLog.WithField("allowedBy", allowedBy)
 
-	newCtx := context.WithValue(ctx, "tokenInfo", token)
+	newCtx := context.WithValue(ctx, tokenClaimsContextKey, token.Claims)
 	authLog.Infof("validated token successfully")
 	return newCtx, nil
 }
+
+// authorizeCaller checks the caller against the identity allowlist and then the
+// caller policy, returning a description of what allowed it.
+func (s *TlsBootstrapServer) authorizeCaller(tokenClaims *AzureADTokenClaims, authLog *logrus.Entry) (string, error) {
+	identity, allowed := s.isAllowedIdentity(tokenClaims.Oid, time.Now())
+	if allowed {
+		return "oid:" + identity.Description, nil
+	}
+
+	allowedBy, overage, err := s.CallerPolicy.Authorize(tokenClaims)
+	if err != nil {
+		return "", newError(codes.PermissionDenied, REASON_GROUP_OVERAGE, "%v", err)
+	}
+	if overage {
+		authLog.Warn("token has a group overage claim; group membership was not evaluated")
+	}
+	if allowedBy != "" {
+		return allowedBy, nil
+	}
+
+	if identity != nil {
+		return "", newError(codes.PermissionDenied, REASON_CALLER_NOT_ALLOWED, "principal ID %s allowlist entry expired at %s", tokenClaims.Oid, identity.Expiry.String())
+	}
+	return "", newError(codes.PermissionDenied, REASON_CALLER_NOT_ALLOWED, "principal ID %s is not in allowed ID list and is not allowed by app ID, role or group", tokenClaims.Oid)
+}
+
+// validateTokenClaims checks the time-based, audience and issuer claims of a token
+// whose signature has already been verified.
+func (s *TlsBootstrapServer) validateTokenClaims(tokenClaims *AzureADTokenClaims, now time.Time) error {
+	if tokenClaims.ExpiresAt == nil {
+		return fmt.Errorf("token has no expiry")
+	}
+	if !tokenClaims.VerifyExpiresAt(now.Add(-s.ClockSkew), true) {
+		return fmt.Errorf("token expired at %s", tokenClaims.ExpiresAt.Time.String())
+	}
+	if !tokenClaims.VerifyNotBefore(now.Add(s.ClockSkew), false) {
+		return fmt.Errorf("token is not valid before %s", tokenClaims.NotBefore.Time.String())
+	}
+
+	if !tokenClaims.VerifyAudience(s.Audience, true) {
+		return fmt.Errorf("token audience %v does not match expected audience %s", tokenClaims.Audience, s.Audience)
+	}
+
+	validIssuer := false
+	for _, issuer := range s.Cloud.TokenIssuers(s.TenantId) {
+		if tokenClaims.Issuer == issuer {
+			validIssuer = true
+			break
+		}
+	}
+	if !validIssuer {
+		return fmt.Errorf("token issuer %s is not a valid issuer for tenant %s", tokenClaims.Issuer, s.TenantId)
+	}
+
+	return nil
+}
+
+func tokenClaimsFromContext(ctx context.Context) (*AzureADTokenClaims, error) {
+	tokenClaims, ok := ctx.Value(tokenClaimsContextKey).(*AzureADTokenClaims)
+	if !ok || tokenClaims == nil {
+		return nil, fmt.Errorf("no validated token claims found in request context")
+	}
+
+	return tokenClaims, nil
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/aks-tls-bootstrap/pkg/azure"
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testTenantId  = "33333333-3333-3333-3333-333333333333"
+	testCallerOid = "44444444-4444-4444-4444-444444444444"
+	testAudience  = "test-audience"
+	testKeyId     = "test-key"
+)
+
+// newTestJWKS serves the public half of key as a JWKS from a local server.
+func newTestJWKS(t *testing.T, key *rsa.PrivateKey) *keyfunc.JWKS {
+	t.Helper()
+
+	jwksJson, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": testKeyId,
+			"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal JWKS: %v", err)
+	}
+
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jwksJson)
+	}))
+	t.Cleanup(jwksServer.Close)
+
+	jwks, err := keyfunc.Get(jwksServer.URL, keyfunc.Options{})
+	if err != nil {
+		t.Fatalf("failed to load JWKS: %v", err)
+	}
+	t.Cleanup(jwks.EndBackground)
+
+	return jwks
+}
+
+func generateTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	return key
+}
+
+func TestValidateToken(t *testing.T) {
+	key := generateTestKey(t)
+	otherKey := generateTestKey(t)
+
+	s := &TlsBootstrapServer{
+		Log:                      newTestLog(),
+		TenantId:                 testTenantId,
+		Cloud:                    azure.AzurePublic,
+		Audience:                 testAudience,
+		AllowedSigningAlgorithms: []string{"RS256"},
+		ClockSkew:                5 * time.Minute,
+		AllowedClientIds:         []string{testCallerOid},
+		jwks:                     newTestJWKS(t, key),
+	}
+
+	validClaims := func() jwt.MapClaims {
+		now := time.Now()
+		return jwt.MapClaims{
+			"aud": testAudience,
+			"iss": azure.AzurePublic.ActiveDirectoryAuthorityHost + testTenantId + "/v2.0",
+			"tid": testTenantId,
+			"oid": testCallerOid,
+			"iat": now.Add(-time.Minute).Unix(),
+			"nbf": now.Add(-time.Minute).Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}
+	}
+	withClaim := func(name string, value interface{}) jwt.MapClaims {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	sign := func(method jwt.SigningMethod, signingKey interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = testKeyId
+		signed, err := token.SignedString(signingKey)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		token  string
+		code   codes.Code
+		reason string
+	}{
+		{
+			name:  "valid token",
+			token: sign(jwt.SigningMethodRS256, key, validClaims()),
+		},
+		{
+			name:   "missing token",
+			token:  "",
+			code:   codes.Unauthenticated,
+			reason: REASON_MISSING_TOKEN,
+		},
+		{
+			name:   "malformed token",
+			token:  "not-a-jwt",
+			code:   codes.Unauthenticated,
+			reason: REASON_INVALID_TOKEN,
+		},
+		{
+			name:   "bad alg HS256",
+			token:  sign(jwt.SigningMethodHS256, []byte("secret"), validClaims()),
+			code:   codes.Unauthenticated,
+			reason: REASON_INVALID_TOKEN,
+		},
+		{
+			name:   "bad alg RS512 not allowed",
+			token:  sign(jwt.SigningMethodRS512, key, validClaims()),
+			code:   codes.Unauthenticated,
+			reason: REASON_INVALID_TOKEN,
+		},
+		{
+			name:   "bad alg none",
+			token:  sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, validClaims()),
+			code:   codes.Unauthenticated,
+			reason: REASON_INVALID_TOKEN,
+		},
+		{
+			name:   "signed by unknown key",
+			token:  sign(jwt.SigningMethodRS256, otherKey, validClaims()),
+			code:   codes.Unauthenticated,
+			reason: REASON_INVALID_TOKEN,
+		},
+		{
+			name:   "expired token",
+			token:  sign(jwt.SigningMethodRS256, key, withClaim("exp", now.Add(-10*time.Minute).Unix())),
+			code:   codes.Unauthenticated,
+			reason: REASON_INVALID_TOKEN,
+		},
+		{
+			name:  "expired within clock skew",
+			token: sign(jwt.SigningMethodRS256, key, withClaim("exp", now.Add(-time.Minute).Unix())),
+		},
+		{
+			name:   "missing expiry",
+			token:  sign(jwt.SigningMethodRS256, key, withClaim("exp", nil)),
+			code:   codes.Unauthenticated,
+			reason: REASON_INVALID_TOKEN,
+		},
+		{
+			name:   "nbf outside clock skew",
+			token:  sign(jwt.SigningMethodRS256, key, withClaim("nbf", now.Add(10*time.Minute).Unix())),
+			code:   codes.Unauthenticated,
+			reason: REASON_INVALID_TOKEN,
+		},
+		{
+			name:  "nbf within clock skew",
+			token: sign(jwt.SigningMethodRS256, key, withClaim("nbf", now.Add(time.Minute).Unix())),
+		},
+		{
+			name:   "wrong audience",
+			token:  sign(jwt.SigningMethodRS256, key, withClaim("aud", "some-other-audience")),
+			code:   codes.Unauthenticated,
+			reason: REASON_INVALID_TOKEN,
+		},
+		{
+			name:   "wrong issuer",
+			token:  sign(jwt.SigningMethodRS256, key, withClaim("iss", "https://login.microsoftonline.com/"+testCallerOid+"/v2.0")),
+			code:   codes.Unauthenticated,
+			reason: REASON_INVALID_TOKEN,
+		},
+		{
+			name:   "wrong tenant",
+			token:  sign(jwt.SigningMethodRS256, key, withClaim("tid", testCallerOid)),
+			code:   codes.Unauthenticated,
+			reason: REASON_TENANT_MISMATCH,
+		},
+		{
+			name:   "caller not allowed",
+			token:  sign(jwt.SigningMethodRS256, key, withClaim("oid", testTenantId)),
+			code:   codes.PermissionDenied,
+			reason: REASON_CALLER_NOT_ALLOWED,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "bearer "+test.token))
+			}
+
+			newCtx, err := s.ValidateToken(ctx)
+			if test.code != codes.OK {
+				if status.Code(err) != test.code || ErrorReason(err) != test.reason {
+					t.Fatalf("expected %s/%s, got %s/%s: %v", test.code, test.reason, status.Code(err), ErrorReason(err), err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			claims, err := tokenClaimsFromContext(newCtx)
+			if err != nil {
+				t.Fatalf("expected claims in context: %v", err)
+			}
+			if claims.Oid != testCallerOid {
+				t.Errorf("expected oid %s, got %s", testCallerOid, claims.Oid)
+			}
+		})
+	}
+}
@@ -2,122 +2,198 @@ package server
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
+	"strings"
+	"time"
 
+	"github.com/Azure/aks-tls-bootstrap/pkg/azure"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
 )
 
-func (s *TlsBootstrapServer) validateVmId(nonce string) error {
-	var authMethod, clientID string
-	azureConfig := &KubeletAzureJson{}
-	azureJson, err := os.ReadFile("/etc/kubernetes/azure.json")
+func (s *TlsBootstrapServer) validateVmId(ctx context.Context, request *Request) (*VirtualMachine, error) {
+	resourceId, err := arm.ParseResourceID(request.ResourceId)
 	if err != nil {
-		log.WithError(err).Info("failed to parse /etc/kubernetes/azure.json")
-		return err
-	} else {
-		err := json.Unmarshal(azureJson, azureConfig)
-		if err != nil {
-			log.WithError(err).Info("failed to unmarshal /etc/kubernetes/azure.json")
-			return err
-		} else {
-			clientID = azureConfig.ClientId
-			if azureConfig.ClientId == "msi" {
-				authMethod = "msi"
-				if azureConfig.UserAssignedIdentityID != "" {
-					// user assigned managed identity
-					// not necessary for system assigned.
-					clientID = azureConfig.UserAssignedIdentityID
-				}
-			} else {
-				authMethod = "sp"
-			}
-		}
+		return nil, newError(codes.InvalidArgument, REASON_INVALID_RESOURCE_ID, "failed to parse resourceId: %s", err)
 	}
 
-	s.Log.Debug("auth method", authMethod)
-	s.Log.Debug("client id ", clientID)
+	s.azureLock.RLock()
+	resolver := s.VMResolver
+	s.azureLock.RUnlock()
 
-	var credential azcore.TokenCredential
-	if authMethod == "msi" {
-		s.Log.Debug("creating msi credential")
-		var c azcore.TokenCredential
-		var err error
-		if clientID == "msi" {
-			c, err = azidentity.NewManagedIdentityCredential(nil)
-		} else {
-			c, err = azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
-				ID: azidentity.ClientID(clientID),
-			})
-		}
-		if err != nil {
-			return fmt.Errorf("failed to get az identity")
-		}
-		credential = c
-	} else {
-		s.Log.Debug("creating sp credential")
-		c, err := azidentity.NewClientSecretCredential(azureConfig.TenantId, clientID, azureConfig.ClientSecret, nil)
-		if err != nil {
-			return fmt.Errorf("failed to get az identity")
-		}
-		credential = c
+	s.Log.WithField("resourceId", resourceId.String()).Debug("retrieving virtual machine from ARM")
+	start := time.Now()
+	vm, err := resolver.GetVirtualMachine(ctx, resourceId)
+	if err != nil {
+		code, reason := armErrorCode(err)
+		err = newError(code, reason, "failed to retrieve virtual machine from ARM: %v", err)
+		armRequestDuration.WithLabelValues(resultLabel(err)).Observe(time.Since(start).Seconds())
+		return nil, err
+	}
+	armRequestDuration.WithLabelValues(RESULT_SUCCESS).Observe(time.Since(start).Seconds())
+	s.Log.WithField("vm", vm).Debug("retrieved virtual machine")
+
+	if vm.VmId == "" {
+		return nil, newError(codes.PermissionDenied, REASON_VM_ID_MISMATCH, "virtual machine %s retrieved from ARM has no VmId", resourceId.String())
+	}
+	if !strings.EqualFold(request.VmId, vm.VmId) {
+		return nil, newError(codes.PermissionDenied, REASON_VM_ID_MISMATCH, "supplied VmId %s does not match VmId %s retrieved from ARM", request.VmId, vm.VmId)
 	}
 
-	s.Log.Debug("fetched az identity")
+	vmName := vm.ComputerName
+	if vmName == "" {
+		vmName = vm.Name
+	}
+	s.Log.WithFields(logrus.Fields{
+		"vmIdFromClient": request.VmId,
+		"vmIdFromARM":    vm.VmId,
+		"vmName":         vmName,
+	}).Info("VmId from client matches VmId retrieved from ARM")
+
+	request.VmName = vmName
+
+	return vm, nil
+}
+
+// initializeVMResolver builds the ARM credential and VM resolver once and rebuilds
+// them whenever azure.json changes, unless a VMResolver was supplied.
+func (s *TlsBootstrapServer) initializeVMResolver() error {
+	if s.VMResolver != nil {
+		return nil
+	}
 
-	resourceId, err := arm.ParseResourceID(s.requests[nonce].ResourceId)
+	if s.AzureConfigPath == "" {
+		s.AzureConfigPath = azure.AZURE_JSON_PATH
+	}
+
+	err := s.reloadVMResolver()
+	if errors.Is(err, fs.ErrNotExist) && s.TenantId != "" {
+		// without azure.json, fall back to the credential from the environment,
+		// such as AZURE_CLIENT_ID/AZURE_CLIENT_SECRET or a managed identity.
+		s.Log.WithField("path", s.AzureConfigPath).Warn("azure.json not found, using default azure credential")
+		err = s.useDefaultAzureCredential()
+	}
 	if err != nil {
-		return fmt.Errorf("failed to parse resourceId: %s", err)
+		return err
+	}
+
+	err = watchFile(s.Log, s.AzureConfigPath, func() {
+		err := s.reloadVMResolver()
+		if err != nil {
+			s.Log.WithError(err).Error("failed to reload azure credential, continuing with previous credential")
+		}
+	})
+	if err != nil && s.azureConfig == nil {
+		// the directory of a missing azure.json may not exist either
+		s.Log.WithError(err).Warn("not watching azure.json for changes")
+		return nil
 	}
+	return err
+}
 
-	armResources, err := armresources.NewClient(resourceId.SubscriptionID, credential, &arm.ClientOptions{
+func (s *TlsBootstrapServer) useDefaultAzureCredential() error {
+	credential, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
 		ClientOptions: azcore.ClientOptions{
-			Cloud: cloud.AzurePublic,
+			Cloud: s.Cloud.Configuration(),
 		},
+		TenantID: s.TenantId,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to get arm client: %s", err)
+		return fmt.Errorf("failed to create default azure credential: %v", err)
 	}
 
-	s.Log.WithField("resourceId", resourceId.String()).Debug("retrieving arm resource")
-	resource, err := armResources.GetByID(context.Background(), s.requests[nonce].ResourceId, "2022-03-01", nil)
+	s.azureLock.Lock()
+	defer s.azureLock.Unlock()
+
+	s.VMResolver = NewARMVMResolver(credential, &arm.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Cloud: s.Cloud.Configuration(),
+		},
+	})
+	return nil
+}
+
+func (s *TlsBootstrapServer) reloadVMResolver() error {
+	azureConfig, err := azure.LoadAzureJson(s.AzureConfigPath)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve resource from ARM: %v", err)
+		return err
 	}
-	s.Log.WithField("resource", resource).Debug("retrieved resource")
 
-	properties := resource.Properties.(map[string]interface{})
+	s.azureLock.Lock()
+	defer s.azureLock.Unlock()
 
-	if s.requests[nonce].VmId != properties["vmId"].(string) {
-		return fmt.Errorf("supplied VmId %s does not match VmId %s retrieved from ARM", s.requests[nonce].VmId, properties["vmId"].(string))
+	if s.azureConfig != nil && *s.azureConfig == *azureConfig {
+		s.Log.Debug("azure config unchanged, keeping existing credential")
+		return nil
 	}
 
-	var vmName string
-	_, hasOsProfile := properties["osProfile"]
-	if hasOsProfile {
-		osProfile := properties["osProfile"].(map[string]interface{})
-		_, hasComputerName := osProfile["computerName"]
-		if hasComputerName {
-			vmName = osProfile["computerName"].(string)
-		} else {
-			vmName = *resource.Name
+	credential, err := s.getAzureCredential(azureConfig)
+	if err != nil {
+		return err
+	}
+
+	// a new resolver starts with an empty per-subscription client cache
+	s.VMResolver = NewARMVMResolver(credential, &arm.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Cloud: s.Cloud.Configuration(),
+		},
+	})
+	s.azureConfig = azureConfig
+	s.Log.WithField("path", s.AzureConfigPath).Info("loaded azure credential")
+
+	return nil
+}
+
+func (s *TlsBootstrapServer) getAzureCredential(azureConfig *azure.KubeletAzureJson) (azcore.TokenCredential, error) {
+	var authMethod, clientID string
+	clientID = azureConfig.ClientId
+	if azureConfig.ClientId == "msi" {
+		authMethod = "msi"
+		if azureConfig.UserAssignedIdentityID != "" {
+			// user assigned managed identity
+			// not necessary for system assigned.
+			clientID = azureConfig.UserAssignedIdentityID
 		}
 	} else {
-		vmName = *resource.Name
+		authMethod = "sp"
 	}
-	s.Log.WithFields(logrus.Fields{
-		"vmIdFromClient": s.requests[nonce].VmId,
-		"vmIdFromARM":    properties["vmId"].(string),
-		"vmName":         vmName,
-	}).Info("VmId from client matches VmId retrieved from ARM")
 
-	s.requests[nonce].VmName = vmName
+	s.Log.Debug("auth method", authMethod)
+	s.Log.Debug("client id ", clientID)
 
-	return nil
+	var credential azcore.TokenCredential
+	if authMethod == "msi" {
+		s.Log.Debug("creating msi credential")
+		options := &azidentity.ManagedIdentityCredentialOptions{
+			ClientOptions: azcore.ClientOptions{
+				Cloud: s.Cloud.Configuration(),
+			},
+		}
+		if clientID != "msi" {
+			options.ID = azidentity.ClientID(clientID)
+		}
+		c, err := azidentity.NewManagedIdentityCredential(options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get az identity: %v", err)
+		}
+		credential = c
+	} else {
+		s.Log.Debug("creating sp credential")
+		c, err := azidentity.NewClientSecretCredential(azureConfig.TenantId, clientID, azureConfig.ClientSecret, &azidentity.ClientSecretCredentialOptions{
+			ClientOptions: azcore.ClientOptions{
+				Cloud: s.Cloud.Configuration(),
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get az identity: %v", err)
+		}
+		credential = c
+	}
+
+	return credential, nil
 }
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testSubscriptionId = "00000000-0000-0000-0000-000000000000"
+	testVmId           = "11111111-1111-1111-1111-111111111111"
+	testVmResourceId   = "/subscriptions/" + testSubscriptionId + "/resourceGroups/mc_rg/providers/Microsoft.Compute/virtualMachines/aks-nodepool1-vm0"
+	testVmssResourceId = "/subscriptions/" + testSubscriptionId + "/resourceGroups/mc_rg/providers/Microsoft.Compute/virtualMachineScaleSets/aks-nodepool1-vmss/virtualMachines/3"
+)
+
+func newTestLog() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logrus.NewEntry(logger)
+}
+
+type fakeCredential struct{}
+
+func (fakeCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeTransport serves canned ARM responses keyed by request path.
+type fakeTransport map[string]string
+
+func (f fakeTransport) Do(request *http.Request) (*http.Response, error) {
+	body, ok := f[strings.ToLower(request.URL.Path)]
+	statusCode := http.StatusOK
+	if !ok {
+		statusCode = http.StatusNotFound
+		body = `{"error":{"code":"ResourceNotFound","message":"not found"}}`
+	}
+
+	return &http.Response{
+		StatusCode: statusCode,
+		Status:     http.StatusText(statusCode),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    request,
+	}, nil
+}
+
+func newFakeARMVMResolver(responses map[string]string) VMResolver {
+	transport := fakeTransport{}
+	for path, body := range responses {
+		transport[strings.ToLower(path)] = body
+	}
+	return NewARMVMResolver(fakeCredential{}, &arm.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Transport: transport,
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+}
+
+func TestValidateVmId(t *testing.T) {
+	fakeResolver := &fakeVMResolver{
+		VirtualMachines: map[string]*VirtualMachine{
+			strings.ToLower(testVmResourceId): {
+				ResourceId:   testVmResourceId,
+				Name:         "aks-nodepool1-vm0",
+				VmId:         testVmId,
+				ComputerName: "aks-nodepool1-computer0",
+			},
+			strings.ToLower(testVmssResourceId): {
+				ResourceId:   testVmssResourceId,
+				Name:         "aks-nodepool1-vmss_3",
+				VmId:         testVmId,
+				ComputerName: "aks-nodepool1-vmss000003",
+				VmssName:     "aks-nodepool1-vmss",
+			},
+		},
+	}
+	noComputerNameResolver := &fakeVMResolver{
+		VirtualMachines: map[string]*VirtualMachine{
+			strings.ToLower(testVmResourceId): {Name: "aks-nodepool1-vm0", VmId: testVmId},
+		},
+	}
+	noVmIdResolver := &fakeVMResolver{
+		VirtualMachines: map[string]*VirtualMachine{
+			strings.ToLower(testVmResourceId): {Name: "aks-nodepool1-vm0"},
+		},
+	}
+
+	armResolver := newFakeARMVMResolver(map[string]string{
+		testVmResourceId: `{
+			"name": "aks-nodepool1-vm0",
+			"tags": {"aks-managed-poolName": "nodepool1"},
+			"zones": ["2"],
+			"properties": {
+				"vmId": "` + testVmId + `",
+				"osProfile": {"computerName": "aks-nodepool1-computer0"},
+				"virtualMachineScaleSet": {"id": "/subscriptions/` + testSubscriptionId + `/resourceGroups/mc_rg/providers/Microsoft.Compute/virtualMachineScaleSets/flex"}
+			}
+		}`,
+		testVmssResourceId: `{
+			"name": "aks-nodepool1-vmss_3",
+			"properties": {
+				"vmId": "` + testVmId + `",
+				"osProfile": {"computerName": "aks-nodepool1-vmss000003"}
+			}
+		}`,
+	})
+	noPropertiesResolver := newFakeARMVMResolver(map[string]string{
+		testVmResourceId:   `{"name": "aks-nodepool1-vm0"}`,
+		testVmssResourceId: `{"name": "aks-nodepool1-vmss_3"}`,
+	})
+
+	tests := []struct {
+		name       string
+		resolver   VMResolver
+		resourceId string
+		vmId       string
+		vmName     string
+		vmssName   string
+		code       codes.Code
+		reason     string
+	}{
+		{name: "standalone vm", resolver: fakeResolver, resourceId: testVmResourceId, vmId: testVmId, vmName: "aks-nodepool1-computer0"},
+		{name: "vmss instance", resolver: fakeResolver, resourceId: testVmssResourceId, vmId: testVmId, vmName: "aks-nodepool1-vmss000003", vmssName: "aks-nodepool1-vmss"},
+		{name: "vm id is case insensitive", resolver: fakeResolver, resourceId: testVmResourceId, vmId: strings.ToUpper(testVmId), vmName: "aks-nodepool1-computer0"},
+		{name: "falls back to resource name", resolver: noComputerNameResolver, resourceId: testVmResourceId, vmId: testVmId, vmName: "aks-nodepool1-vm0"},
+		{name: "vm id mismatch", resolver: fakeResolver, resourceId: testVmResourceId, vmId: "22222222-2222-2222-2222-222222222222", code: codes.PermissionDenied, reason: REASON_VM_ID_MISMATCH},
+		{name: "vm without vm id", resolver: noVmIdResolver, resourceId: testVmResourceId, vmId: testVmId, code: codes.PermissionDenied, reason: REASON_VM_ID_MISMATCH},
+		{name: "vm not found", resolver: &fakeVMResolver{}, resourceId: testVmResourceId, vmId: testVmId, code: codes.PermissionDenied, reason: REASON_VM_NOT_FOUND},
+		{name: "invalid resource id", resolver: fakeResolver, resourceId: "not-a-resource-id", vmId: testVmId, code: codes.InvalidArgument, reason: REASON_INVALID_RESOURCE_ID},
+		{name: "transient arm failure", resolver: &fakeVMResolver{Err: fmt.Errorf("connection reset")}, resourceId: testVmResourceId, vmId: testVmId, code: codes.Unavailable, reason: REASON_VM_LOOKUP_FAILED},
+
+		{name: "arm standalone vm", resolver: armResolver, resourceId: testVmResourceId, vmId: testVmId, vmName: "aks-nodepool1-computer0", vmssName: "flex"},
+		{name: "arm vmss instance", resolver: armResolver, resourceId: testVmssResourceId, vmId: testVmId, vmName: "aks-nodepool1-vmss000003", vmssName: "aks-nodepool1-vmss"},
+		{name: "arm vm not found", resolver: armResolver, resourceId: strings.Replace(testVmResourceId, "vm0", "vm9", 1), vmId: testVmId, code: codes.PermissionDenied, reason: REASON_VM_NOT_FOUND},
+		{name: "arm vm without properties", resolver: noPropertiesResolver, resourceId: testVmResourceId, vmId: testVmId, code: codes.PermissionDenied, reason: REASON_VM_INCOMPLETE},
+		{name: "arm vmss instance without properties", resolver: noPropertiesResolver, resourceId: testVmssResourceId, vmId: testVmId, code: codes.PermissionDenied, reason: REASON_VM_INCOMPLETE},
+		{name: "arm unsupported resource type", resolver: armResolver, resourceId: "/subscriptions/" + testSubscriptionId + "/resourceGroups/mc_rg/providers/Microsoft.Compute/disks/disk0", vmId: testVmId, code: codes.InvalidArgument, reason: REASON_UNSUPPORTED_RESOURCE_TYPE},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &TlsBootstrapServer{Log: newTestLog(), VMResolver: test.resolver}
+			request := &Request{ResourceId: test.resourceId, VmId: test.vmId}
+
+			vm, err := s.validateVmId(context.Background(), request)
+			if test.code != codes.OK {
+				if status.Code(err) != test.code || ErrorReason(err) != test.reason {
+					t.Fatalf("expected %s/%s, got %s/%s: %v", test.code, test.reason, status.Code(err), ErrorReason(err), err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if request.VmName != test.vmName {
+				t.Errorf("expected vm name %q, got %q", test.vmName, request.VmName)
+			}
+			if vm.VmssName != test.vmssName {
+				t.Errorf("expected scale set name %q, got %q", test.vmssName, vm.VmssName)
+			}
+		})
+	}
+}
+
+func TestVmssResolverRequiresParent(t *testing.T) {
+	resolver := &vmssVirtualMachineResolver{}
+	_, err := resolver.GetVirtualMachine(context.Background(), &arm.ResourceID{
+		SubscriptionID:    testSubscriptionId,
+		ResourceGroupName: "mc_rg",
+		ResourceType:      arm.NewResourceType("Microsoft.Compute", "virtualMachineScaleSets/virtualMachines"),
+		Name:              "3",
+	})
+	if !errors.Is(err, ErrNoParentScaleSet) {
+		t.Fatalf("expected ErrNoParentScaleSet, got %v", err)
+	}
+
+	s := &TlsBootstrapServer{Log: newTestLog(), VMResolver: &fakeVMResolver{Err: err}}
+	_, err = s.validateVmId(context.Background(), &Request{ResourceId: testVmssResourceId, VmId: testVmId})
+	if status.Code(err) != codes.InvalidArgument || ErrorReason(err) != REASON_INVALID_RESOURCE_ID {
+		t.Errorf("expected InvalidArgument/%s, got %s/%s", REASON_INVALID_RESOURCE_ID, status.Code(err), ErrorReason(err))
+	}
+}
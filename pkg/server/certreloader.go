@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CertificateReloader serves a TLS certificate and key from disk, reloading them when
+// either file changes so that rotated certificates are picked up without a restart.
+type CertificateReloader struct {
+	certPath    string
+	keyPath     string
+	log         *logrus.Entry
+	certificate atomic.Value
+}
+
+func NewCertificateReloader(log *logrus.Entry, certPath string, keyPath string) (*CertificateReloader, error) {
+	c := &CertificateReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		log:      log.WithFields(logrus.Fields{"tls-cert": certPath, "tls-key": keyPath}),
+	}
+
+	err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range []string{certPath, keyPath} {
+		err = watchFile(c.log, path, func() {
+			err := c.reload()
+			if err != nil {
+				// the certificate and key may be written separately; keep serving the
+				// previous pair until both match.
+				c.log.WithError(err).Warn("failed to reload TLS certificate, keeping previous certificate")
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch %s: %v", path, err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *CertificateReloader) reload() error {
+	certificate, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse TLS certificate: %v", err)
+	}
+	certificate.Leaf = leaf
+
+	previous, _ := c.certificate.Load().(*tls.Certificate)
+	if previous != nil && previous.Leaf.Equal(leaf) {
+		return nil
+	}
+
+	c.certificate.Store(&certificate)
+	c.log.WithFields(logrus.Fields{
+		"subject":  leaf.Subject.String(),
+		"serial":   leaf.SerialNumber.String(),
+		"notAfter": leaf.NotAfter.String(),
+	}).Info("loaded TLS certificate")
+
+	return nil
+}
+
+// GetCertificate returns the most recently loaded certificate, for use as tls.Config.GetCertificate.
+func (c *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.certificate.Load().(*tls.Certificate), nil
+}
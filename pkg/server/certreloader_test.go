@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testKeyPair struct {
+	certPEM []byte
+	keyPEM  []byte
+	serial  *big.Int
+}
+
+func newTestKeyPair(t *testing.T, serial int64) *testKeyPair {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "tls-bootstrap"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	return &testKeyPair{
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		serial:  template.SerialNumber,
+	}
+}
+
+// writeFileAtomically replaces path with data in a single rename, as the watched
+// files would otherwise be seen half written.
+func writeFileAtomically(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, data, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", temporary, err)
+	}
+	if err := os.Rename(temporary, path); err != nil {
+		t.Fatalf("failed to rename %s: %v", temporary, err)
+	}
+}
+
+func newTestCertificateReloader(t *testing.T, pair *testKeyPair) (*CertificateReloader, string, string) {
+	t.Helper()
+
+	directory := t.TempDir()
+	certPath := filepath.Join(directory, "tls.crt")
+	keyPath := filepath.Join(directory, "tls.key")
+	writeFileAtomically(t, certPath, pair.certPEM)
+	writeFileAtomically(t, keyPath, pair.keyPEM)
+
+	reloader, err := NewCertificateReloader(newTestLog(), certPath, keyPath)
+	if err != nil {
+		t.Fatalf("failed to create certificate reloader: %v", err)
+	}
+	return reloader, certPath, keyPath
+}
+
+func servedSerial(t *testing.T, reloader *CertificateReloader) *big.Int {
+	t.Helper()
+
+	certificate, err := reloader.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("unexpected error getting certificate: %v", err)
+	}
+	return certificate.Leaf.SerialNumber
+}
+
+func TestCertificateReloaderReloadsChangedFiles(t *testing.T) {
+	first := newTestKeyPair(t, 1)
+	second := newTestKeyPair(t, 2)
+	reloader, certPath, keyPath := newTestCertificateReloader(t, first)
+
+	if serial := servedSerial(t, reloader); serial.Cmp(first.serial) != 0 {
+		t.Fatalf("expected the initial certificate %s, got %s", first.serial, serial)
+	}
+
+	writeFileAtomically(t, keyPath, second.keyPEM)
+	writeFileAtomically(t, certPath, second.certPEM)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for servedSerial(t, reloader).Cmp(second.serial) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the new certificate to be served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCertificateReloaderKeepsPreviousPairOnFailure(t *testing.T) {
+	first := newTestKeyPair(t, 1)
+	second := newTestKeyPair(t, 2)
+
+	tests := []struct {
+		name    string
+		certPEM []byte
+		keyPEM  []byte
+	}{
+		{"invalid certificate", []byte("not a certificate"), first.keyPEM},
+		{"key of another certificate", second.certPEM, first.keyPEM},
+		{"empty key", first.certPEM, []byte{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reloader, certPath, keyPath := newTestCertificateReloader(t, first)
+
+			writeFileAtomically(t, certPath, test.certPEM)
+			writeFileAtomically(t, keyPath, test.keyPEM)
+			if err := reloader.reload(); err == nil {
+				t.Fatalf("expected reloading an invalid pair to fail")
+			}
+			if serial := servedSerial(t, reloader); serial.Cmp(first.serial) != 0 {
+				t.Errorf("expected the previous certificate %s to be kept, got %s", first.serial, serial)
+			}
+		})
+	}
+}
+
+func TestNewCertificateReloaderInvalidPair(t *testing.T) {
+	pair := newTestKeyPair(t, 1)
+	directory := t.TempDir()
+	certPath := filepath.Join(directory, "tls.crt")
+	keyPath := filepath.Join(directory, "tls.key")
+	writeFileAtomically(t, certPath, pair.certPEM)
+
+	if _, err := NewCertificateReloader(newTestLog(), certPath, keyPath); err == nil {
+		t.Errorf("expected an error for a missing key")
+	}
+}
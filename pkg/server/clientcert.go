@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// client authentication modes
+const (
+	// CLIENT_AUTH_TOKEN authenticates callers by their Azure AD bearer token only.
+	CLIENT_AUTH_TOKEN = "token"
+	// CLIENT_AUTH_CERTIFICATE authenticates callers by a client certificate issued by
+	// the client CA; the prefixed certificate identity replaces the token's oid.
+	CLIENT_AUTH_CERTIFICATE = "certificate"
+	// CLIENT_AUTH_CERTIFICATE_AND_TOKEN requires both a client certificate with an
+	// allowed identity and an allowed bearer token; the token's oid remains the
+	// caller identity.
+	CLIENT_AUTH_CERTIFICATE_AND_TOKEN = "certificate-and-token"
+)
+
+// RequiresClientCertificate reports whether the client authentication mode needs
+// the TLS layer to request and verify client certificates.
+func RequiresClientCertificate(mode string) bool {
+	return mode == CLIENT_AUTH_CERTIFICATE || mode == CLIENT_AUTH_CERTIFICATE_AND_TOKEN
+}
+
+// CERTIFICATE_IDENTITY_PREFIX is prepended to a client certificate identity when it
+// stands in for the caller's oid, so it can never be mistaken for an Azure AD object ID.
+const CERTIFICATE_IDENTITY_PREFIX = "cert:"
+
+func (s *TlsBootstrapServer) validateClientAuthMode() error {
+	switch s.ClientAuthMode {
+	case "":
+		s.ClientAuthMode = CLIENT_AUTH_TOKEN
+	case CLIENT_AUTH_TOKEN:
+	case CLIENT_AUTH_CERTIFICATE, CLIENT_AUTH_CERTIFICATE_AND_TOKEN:
+		if len(s.AllowedCertificateIdentities) == 0 {
+			return fmt.Errorf("client authentication mode %s requires at least one allowed certificate identity", s.ClientAuthMode)
+		}
+	default:
+		return fmt.Errorf("unknown client authentication mode %q", s.ClientAuthMode)
+	}
+	return nil
+}
+
+// authenticate validates the caller according to the client authentication mode.
+func (s *TlsBootstrapServer) authenticate(ctx context.Context) (context.Context, error) {
+	switch s.ClientAuthMode {
+	case CLIENT_AUTH_CERTIFICATE:
+		return s.ValidateClientCertificate(ctx)
+	case CLIENT_AUTH_CERTIFICATE_AND_TOKEN:
+		_, _, err := s.authorizeClientCertificate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return s.ValidateToken(ctx)
+	default:
+		return s.ValidateToken(ctx)
+	}
+}
+
+// ValidateClientCertificate authorizes the caller by the identity of its verified
+// client certificate. The identity, prefixed with CERTIFICATE_IDENTITY_PREFIX, is
+// stored in the request context as the caller's oid.
+func (s *TlsBootstrapServer) ValidateClientCertificate(ctx context.Context) (context.Context, error) {
+	certificate, identity, err := s.authorizeClientCertificate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenClaims := &AzureADTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: certificate.Subject.String(),
+		},
+		Oid: CERTIFICATE_IDENTITY_PREFIX + identity,
+		Tid: s.TenantId,
+	}
+	return context.WithValue(ctx, tokenClaimsContextKey, tokenClaims), nil
+}
+
+// authorizeClientCertificate returns the verified client certificate and its identity
+// if the identity is one of AllowedCertificateIdentities.
+func (s *TlsBootstrapServer) authorizeClientCertificate(ctx context.Context) (*x509.Certificate, string, error) {
+	s.Log.Infof("validating client certificate")
+	certificate, err := clientCertificateFromContext(ctx)
+	if err != nil {
+		err = newError(codes.Unauthenticated, REASON_MISSING_CLIENT_CERTIFICATE, "%v", err)
+		s.Log.Error(err)
+		return nil, "", err
+	}
+
+	identity := certificateIdentity(certificate)
+	authLog := s.Log.WithFields(logrus.Fields{
+		"certificateIdentity": identity,
+		"serial":              certificate.SerialNumber.String(),
+	})
+	if identity == "" {
+		err = newError(codes.Unauthenticated, REASON_MISSING_CLIENT_CERTIFICATE, "client certificate %s has no subject common name or SAN", certificate.SerialNumber.String())
+		authLog.Error(err)
+		return nil, "", err
+	}
+
+	if !s.isAllowedCertificateIdentity(identity) {
+		err = newError(codes.PermissionDenied, REASON_CALLER_NOT_ALLOWED, "client certificate identity %s is not an allowed certificate identity", identity)
+		authLog.Error(err)
+		return nil, "", err
+	}
+
+	authLog.Info("validated client certificate successfully")
+	return certificate, identity, nil
+}
+
+func (s *TlsBootstrapServer) isAllowedCertificateIdentity(identity string) bool {
+	for _, allowed := range s.AllowedCertificateIdentities {
+		if allowed != "" && strings.EqualFold(identity, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientCertificateFromContext returns the leaf of the client certificate chain
+// verified during the TLS handshake.
+func clientCertificateFromContext(ctx context.Context) (*x509.Certificate, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("no peer found in request context")
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, fmt.Errorf("connection is not using TLS")
+	}
+
+	if len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, fmt.Errorf("no verified client certificate was presented")
+	}
+
+	return tlsInfo.State.VerifiedChains[0][0], nil
+}
+
+// certificateIdentity prefers the first URI SAN, such as a SPIFFE ID, then the
+// first DNS SAN and finally the subject common name.
+func certificateIdentity(certificate *x509.Certificate) string {
+	if len(certificate.URIs) > 0 {
+		return certificate.URIs[0].String()
+	}
+	if len(certificate.DNSNames) > 0 {
+		return strings.ToLower(certificate.DNSNames[0])
+	}
+	return certificate.Subject.CommonName
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Azure/aks-tls-bootstrap/pkg/azure"
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const testCertificateIdentity = "spiffe://aks/node/test"
+
+// withClientCertificate returns a context carrying certificate as the verified
+// client certificate of the gRPC peer.
+func withClientCertificate(ctx context.Context, certificate *x509.Certificate) context.Context {
+	return peer.NewContext(ctx, &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}},
+		},
+	})
+}
+
+func newTestClientCertificate(commonName string, dnsNames []string, uris ...string) *x509.Certificate {
+	certificate := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+	}
+	for _, uri := range uris {
+		parsed, _ := url.Parse(uri)
+		certificate.URIs = append(certificate.URIs, parsed)
+	}
+	return certificate
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	tests := []struct {
+		name        string
+		certificate *x509.Certificate
+		expected    string
+	}{
+		{"uri san wins", newTestClientCertificate("cn", []string{"node.example.com"}, testCertificateIdentity), testCertificateIdentity},
+		{"dns san over common name", newTestClientCertificate("cn", []string{"Node.Example.com", "other.example.com"}), "node.example.com"},
+		{"common name", newTestClientCertificate("cn", nil), "cn"},
+		{"no identity", newTestClientCertificate("", nil), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := certificateIdentity(test.certificate); got != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateClientAuthMode(t *testing.T) {
+	tests := []struct {
+		mode         string
+		certIds      []string
+		expectedMode string
+		expectErr    bool
+	}{
+		{mode: "", expectedMode: CLIENT_AUTH_TOKEN},
+		{mode: CLIENT_AUTH_TOKEN, expectedMode: CLIENT_AUTH_TOKEN},
+		{mode: CLIENT_AUTH_CERTIFICATE, certIds: []string{testCertificateIdentity}, expectedMode: CLIENT_AUTH_CERTIFICATE},
+		{mode: CLIENT_AUTH_CERTIFICATE_AND_TOKEN, certIds: []string{testCertificateIdentity}, expectedMode: CLIENT_AUTH_CERTIFICATE_AND_TOKEN},
+		{mode: CLIENT_AUTH_CERTIFICATE, expectErr: true},
+		{mode: CLIENT_AUTH_CERTIFICATE_AND_TOKEN, expectErr: true},
+		{mode: "mtls", certIds: []string{testCertificateIdentity}, expectErr: true},
+	}
+
+	for _, test := range tests {
+		s := &TlsBootstrapServer{ClientAuthMode: test.mode, AllowedCertificateIdentities: test.certIds}
+		err := s.validateClientAuthMode()
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("expected mode %q with certificate identities %v to be rejected", test.mode, test.certIds)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for mode %q: %v", test.mode, err)
+		}
+		if s.ClientAuthMode != test.expectedMode {
+			t.Errorf("expected mode %q to become %q, got %q", test.mode, test.expectedMode, s.ClientAuthMode)
+		}
+	}
+}
+
+func TestAuthenticateClientAuthModes(t *testing.T) {
+	key := generateTestKey(t)
+	jwks := newTestJWKS(t, key)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"aud": testAudience,
+		"iss": azure.AzurePublic.ActiveDirectoryAuthorityHost + testTenantId + "/v2.0",
+		"tid": testTenantId,
+		"oid": testCallerOid,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = testKeyId
+	signedToken, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	withToken := func(ctx context.Context) context.Context {
+		return metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "bearer "+signedToken))
+	}
+	allowedCertificate := newTestClientCertificate("ignored", nil, testCertificateIdentity)
+	// a certificate named after an allowed Azure AD oid must not pass as that oid
+	oidCertificate := newTestClientCertificate(testCallerOid, nil)
+
+	tests := []struct {
+		name        string
+		mode        string
+		ctx         context.Context
+		code        codes.Code
+		reason      string
+		expectedOid string
+	}{
+		{
+			name:        "token mode with token",
+			mode:        CLIENT_AUTH_TOKEN,
+			ctx:         withToken(context.Background()),
+			expectedOid: testCallerOid,
+		},
+		{
+			name:   "token mode ignores certificate",
+			mode:   CLIENT_AUTH_TOKEN,
+			ctx:    withClientCertificate(context.Background(), allowedCertificate),
+			code:   codes.Unauthenticated,
+			reason: REASON_MISSING_TOKEN,
+		},
+		{
+			name:        "certificate mode with allowed certificate",
+			mode:        CLIENT_AUTH_CERTIFICATE,
+			ctx:         withClientCertificate(context.Background(), allowedCertificate),
+			expectedOid: CERTIFICATE_IDENTITY_PREFIX + testCertificateIdentity,
+		},
+		{
+			name:   "certificate mode without certificate",
+			mode:   CLIENT_AUTH_CERTIFICATE,
+			ctx:    withToken(context.Background()),
+			code:   codes.Unauthenticated,
+			reason: REASON_MISSING_CLIENT_CERTIFICATE,
+		},
+		{
+			name:   "certificate mode with certificate named after an allowed oid",
+			mode:   CLIENT_AUTH_CERTIFICATE,
+			ctx:    withClientCertificate(context.Background(), oidCertificate),
+			code:   codes.PermissionDenied,
+			reason: REASON_CALLER_NOT_ALLOWED,
+		},
+		{
+			name:   "certificate mode with certificate without identity",
+			mode:   CLIENT_AUTH_CERTIFICATE,
+			ctx:    withClientCertificate(context.Background(), newTestClientCertificate("", nil)),
+			code:   codes.Unauthenticated,
+			reason: REASON_MISSING_CLIENT_CERTIFICATE,
+		},
+		{
+			name:        "certificate and token mode with both",
+			mode:        CLIENT_AUTH_CERTIFICATE_AND_TOKEN,
+			ctx:         withToken(withClientCertificate(context.Background(), allowedCertificate)),
+			expectedOid: testCallerOid,
+		},
+		{
+			name:   "certificate and token mode without token",
+			mode:   CLIENT_AUTH_CERTIFICATE_AND_TOKEN,
+			ctx:    withClientCertificate(context.Background(), allowedCertificate),
+			code:   codes.Unauthenticated,
+			reason: REASON_MISSING_TOKEN,
+		},
+		{
+			name:   "certificate and token mode without certificate",
+			mode:   CLIENT_AUTH_CERTIFICATE_AND_TOKEN,
+			ctx:    withToken(context.Background()),
+			code:   codes.Unauthenticated,
+			reason: REASON_MISSING_CLIENT_CERTIFICATE,
+		},
+		{
+			name:   "certificate and token mode with certificate not allowed",
+			mode:   CLIENT_AUTH_CERTIFICATE_AND_TOKEN,
+			ctx:    withToken(withClientCertificate(context.Background(), oidCertificate)),
+			code:   codes.PermissionDenied,
+			reason: REASON_CALLER_NOT_ALLOWED,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &TlsBootstrapServer{
+				Log:                          newTestLog(),
+				TenantId:                     testTenantId,
+				Cloud:                        azure.AzurePublic,
+				Audience:                     testAudience,
+				AllowedSigningAlgorithms:     []string{"RS256"},
+				AllowedClientIds:             []string{testCallerOid},
+				AllowedCertificateIdentities: []string{testCertificateIdentity},
+				ClientAuthMode:               test.mode,
+				jwks:                         jwks,
+			}
+
+			newCtx, err := s.authenticate(test.ctx)
+			if test.code != codes.OK {
+				if status.Code(err) != test.code || ErrorReason(err) != test.reason {
+					t.Fatalf("expected %s/%s, got %s/%s: %v", test.code, test.reason, status.Code(err), ErrorReason(err), err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			claims, err := tokenClaimsFromContext(newCtx)
+			if err != nil {
+				t.Fatalf("expected claims in context: %v", err)
+			}
+			if claims.Oid != test.expectedOid {
+				t.Errorf("expected oid %s, got %s", test.expectedOid, claims.Oid)
+			}
+		})
+	}
+}
@@ -4,7 +4,19 @@ import (
 	"time"
 )
 
+const DEFAULT_CLOCK_SKEW = 5 * time.Minute
 const JWKS_REFRESH_INTERVAL = 1 * time.Hour
 const NONCE_EXPIRATION_CHECK_INTERVAL = 1 * time.Minute
+const NONCE_LENGTH = 32
 const NONCE_LIFETIME = 30 * time.Second
 const TOKEN_LIFETIME = 30 * time.Second
+const TOKEN_GC_INTERVAL = 1 * time.Minute
+
+const NONCE_STORE_MEMORY = "memory"
+const NONCE_STORE_KUBERNETES = "kubernetes"
+
+// DEFAULT_SIGNING_ALGORITHM is the algorithm Azure AD signs access tokens with.
+const DEFAULT_SIGNING_ALGORITHM = "RS256"
+
+const HEALTH_CHECK_INTERVAL = 10 * time.Second
+const HEALTH_CHECK_TIMEOUT = 5 * time.Second
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ERROR_DOMAIN is the domain of the google.rpc.ErrorInfo detail attached to errors.
+const ERROR_DOMAIN = "tlsbootstrap.aks.azure.com"
+
+// Stable reasons attached to errors returned to clients.
+const (
+	REASON_MISSING_CLIENT_CERTIFICATE = "MISSING_CLIENT_CERTIFICATE"
+	REASON_MISSING_TOKEN              = "MISSING_TOKEN"
+	REASON_INVALID_TOKEN              = "INVALID_TOKEN"
+	REASON_TENANT_MISMATCH            = "TENANT_MISMATCH"
+	REASON_CALLER_NOT_ALLOWED         = "CALLER_NOT_ALLOWED"
+	REASON_GROUP_OVERAGE              = "GROUP_OVERAGE"
+	REASON_INVALID_RESOURCE_ID        = "INVALID_RESOURCE_ID"
+	REASON_UNSUPPORTED_RESOURCE_TYPE  = "UNSUPPORTED_RESOURCE_TYPE"
+	REASON_NONCE_GENERATION_FAILED    = "NONCE_GENERATION_FAILED"
+	REASON_NONCE_STORE_UNAVAILABLE    = "NONCE_STORE_UNAVAILABLE"
+	REASON_NONCE_NOT_FOUND            = "NONCE_NOT_FOUND"
+	REASON_NONCE_EXPIRED              = "NONCE_EXPIRED"
+	REASON_NONCE_ALREADY_USED         = "NONCE_ALREADY_USED"
+	REASON_REQUEST_MISMATCH           = "REQUEST_MISMATCH"
+	REASON_ATTESTED_DATA_INVALID      = "ATTESTED_DATA_INVALID"
+	REASON_INTERMEDIATE_UNAVAILABLE   = "INTERMEDIATE_CERTIFICATE_UNAVAILABLE"
+	REASON_VM_NOT_FOUND               = "VM_NOT_FOUND"
+	REASON_VM_INCOMPLETE              = "VM_INCOMPLETE"
+	REASON_VM_LOOKUP_FAILED           = "VM_LOOKUP_FAILED"
+	REASON_VM_ID_MISMATCH             = "VM_ID_MISMATCH"
+	REASON_ADMISSION_DENIED           = "ADMISSION_DENIED"
+	REASON_TOKEN_CREATION_FAILED      = "TOKEN_CREATION_FAILED"
+)
+
+// newError returns a gRPC status error with a google.rpc.ErrorInfo detail carrying reason.
+func newError(code codes.Code, reason string, format string, a ...interface{}) error {
+	st := status.New(code, fmt.Sprintf(format, a...))
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: ERROR_DOMAIN,
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// ErrorReason returns the reason from the ErrorInfo detail of a gRPC status error,
+// or an empty string if there is none.
+func ErrorReason(err error) string {
+	st, ok := status.FromError(err)
+	if !ok {
+		return ""
+	}
+
+	for _, detail := range st.Details() {
+		if errorInfo, ok := detail.(*errdetails.ErrorInfo); ok {
+			return errorInfo.Reason
+		}
+	}
+
+	return ""
+}
+
+// armErrorCode maps an error from an ARM request to a gRPC code and reason.
+// Only failures which may succeed on retry are reported as Unavailable.
+func armErrorCode(err error) (codes.Code, string) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded, REASON_VM_LOOKUP_FAILED
+	case errors.Is(err, ErrUnsupportedResourceType):
+		return codes.InvalidArgument, REASON_UNSUPPORTED_RESOURCE_TYPE
+	case errors.Is(err, ErrNoParentScaleSet):
+		return codes.InvalidArgument, REASON_INVALID_RESOURCE_ID
+	case errors.Is(err, ErrVirtualMachineHasNoProperties):
+		return codes.PermissionDenied, REASON_VM_INCOMPLETE
+	}
+
+	var responseError *azcore.ResponseError
+	if errors.As(err, &responseError) {
+		switch {
+		case responseError.StatusCode == http.StatusNotFound:
+			return codes.PermissionDenied, REASON_VM_NOT_FOUND
+		case responseError.StatusCode == http.StatusBadRequest:
+			return codes.InvalidArgument, REASON_INVALID_RESOURCE_ID
+		case responseError.StatusCode == http.StatusTooManyRequests || responseError.StatusCode >= http.StatusInternalServerError:
+			return codes.Unavailable, REASON_VM_LOOKUP_FAILED
+		default:
+			// e.g. the server's own credential is not authorized to read the VM
+			return codes.Internal, REASON_VM_LOOKUP_FAILED
+		}
+	}
+
+	return codes.Unavailable, REASON_VM_LOOKUP_FAILED
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"google.golang.org/grpc/codes"
+)
+
+func TestArmErrorCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{"deadline", fmt.Errorf("get: %w", context.DeadlineExceeded), codes.DeadlineExceeded, REASON_VM_LOOKUP_FAILED},
+		{"unsupported resource type", fmt.Errorf("%w Microsoft.Compute/disks", ErrUnsupportedResourceType), codes.InvalidArgument, REASON_UNSUPPORTED_RESOURCE_TYPE},
+		{"no parent scale set", fmt.Errorf("vm: %w", ErrNoParentScaleSet), codes.InvalidArgument, REASON_INVALID_RESOURCE_ID},
+		{"no properties", fmt.Errorf("vm: %w", ErrVirtualMachineHasNoProperties), codes.PermissionDenied, REASON_VM_INCOMPLETE},
+		{"not found", &azcore.ResponseError{StatusCode: http.StatusNotFound}, codes.PermissionDenied, REASON_VM_NOT_FOUND},
+		{"bad request", &azcore.ResponseError{StatusCode: http.StatusBadRequest}, codes.InvalidArgument, REASON_INVALID_RESOURCE_ID},
+		{"forbidden", &azcore.ResponseError{StatusCode: http.StatusForbidden}, codes.Internal, REASON_VM_LOOKUP_FAILED},
+		{"throttled", &azcore.ResponseError{StatusCode: http.StatusTooManyRequests}, codes.Unavailable, REASON_VM_LOOKUP_FAILED},
+		{"server error", fmt.Errorf("get: %w", &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable}), codes.Unavailable, REASON_VM_LOOKUP_FAILED},
+		{"network error", fmt.Errorf("dial tcp: connection refused"), codes.Unavailable, REASON_VM_LOOKUP_FAILED},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, reason := armErrorCode(test.err)
+			if code != test.code || reason != test.reason {
+				t.Errorf("expected %s/%s, got %s/%s", test.code, test.reason, code, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// watchFile calls onChange whenever the file at path is written, created or replaced.
+// The parent directory is watched so that atomic replacements, such as Kubernetes
+// secret and configmap volume updates, are detected.
+func watchFile(log *logrus.Entry, path string, onChange func()) error {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path %s to absolute path: %v", path, err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %v", err)
+	}
+
+	directory := filepath.Dir(absolutePath)
+	err = watcher.Add(directory)
+	if err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch directory %s: %v", directory, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				// kubernetes volume updates swap the ..data symlink rather than the file itself
+				name := filepath.Base(event.Name)
+				if event.Name != absolutePath && name != "..data" {
+					continue
+				}
+				log.WithFields(logrus.Fields{
+					"path":  absolutePath,
+					"event": event.Op.String(),
+				}).Debug("watched file changed")
+				onChange()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).WithField("path", absolutePath).Error("error watching file")
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	pb "github.com/Azure/aks-tls-bootstrap/pkg/proto"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthStatus holds the result of the last health check; atomic.Value cannot store a nil error.
+type healthStatus struct {
+	err error
+}
+
+// jwksRefreshRecorder records when the JWKS was last fetched successfully so that
+// health checks can tell whether the signing keys are going stale.
+type jwksRefreshRecorder struct {
+	next      http.RoundTripper
+	refreshed func(time.Time)
+}
+
+func (j *jwksRefreshRecorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := j.next.RoundTrip(request)
+	if err == nil && response.StatusCode == http.StatusOK {
+		j.refreshed(time.Now())
+	}
+	return response, err
+}
+
+// CheckHealth verifies that the JWKS is fresh, the API server is reachable and the
+// root certificate pool is loaded.
+func (s *TlsBootstrapServer) CheckHealth(ctx context.Context) error {
+	if s.jwks == nil || len(s.jwks.KIDs()) == 0 {
+		return fmt.Errorf("no JWKS keys are loaded")
+	}
+	lastRefresh, _ := s.jwksLastRefresh.Load().(time.Time)
+	// allow one failed background refresh before reporting the keys as stale
+	if time.Since(lastRefresh) > 2*s.JwksRefreshInterval {
+		return fmt.Errorf("JWKS has not been refreshed since %s", lastRefresh.Format(time.RFC3339))
+	}
+
+	if s.rootCertPool == nil {
+		return fmt.Errorf("root certificate pool is not loaded")
+	}
+	if s.RootCertPath != "" && len(s.rootCertPool.Subjects()) == 0 {
+		return fmt.Errorf("root certificate pool is empty")
+	}
+
+	if s.k8sClientSet != nil {
+		_, err := s.k8sClientSet.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+		if err != nil {
+			return fmt.Errorf("API server is unreachable: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// WatchHealth periodically runs CheckHealth and reports the result through the
+// gRPC health service for both the server as a whole and the token request service.
+func (s *TlsBootstrapServer) WatchHealth(healthServer *health.Server) {
+	for {
+		s.updateHealth(healthServer)
+		time.Sleep(HEALTH_CHECK_INTERVAL)
+	}
+}
+
+// updateHealth runs CheckHealth once and records the result for the readiness probe
+// and the gRPC health service.
+func (s *TlsBootstrapServer) updateHealth(healthServer *health.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), HEALTH_CHECK_TIMEOUT)
+	err := s.CheckHealth(ctx)
+	cancel()
+
+	previous, _ := s.healthStatus.Load().(healthStatus)
+	s.healthStatus.Store(healthStatus{err: err})
+
+	status := healthpb.HealthCheckResponse_SERVING
+	if err != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+		if previous.err == nil {
+			s.Log.Warnf("server is unhealthy: %v", err)
+		}
+	} else if previous.err != nil {
+		s.Log.Info("server is healthy")
+	}
+	healthServer.SetServingStatus("", status)
+	healthServer.SetServingStatus(pb.AKSBootstrapTokenRequest_ServiceDesc.ServiceName, status)
+}
+
+// HealthHandler serves HTTP liveness (/healthz) and readiness (/readyz) probes. The
+// readiness probe reports the result of the last health check run by WatchHealth.
+func (s *TlsBootstrapServer) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status, checked := s.healthStatus.Load().(healthStatus)
+		if !checked {
+			http.Error(w, "health has not been checked yet", http.StatusServiceUnavailable)
+			return
+		}
+		if status.err != nil {
+			http.Error(w, status.err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/Azure/aks-tls-bootstrap/pkg/proto"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// newTestAPIServer returns a clientset for a local API server which only serves /version.
+func newTestAPIServer(t *testing.T) (*httptest.Server, kubernetes.Interface) {
+	t.Helper()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"major":"1","minor":"25","gitVersion":"v1.25.0"}`))
+	}))
+	t.Cleanup(apiServer.Close)
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: apiServer.URL, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("failed to create clientset: %v", err)
+	}
+	return apiServer, clientset
+}
+
+// newHealthyTestServer returns a server which passes CheckHealth.
+func newHealthyTestServer(t *testing.T) (*TlsBootstrapServer, *httptest.Server) {
+	t.Helper()
+
+	apiServer, clientset := newTestAPIServer(t)
+	s := &TlsBootstrapServer{
+		Log:                 newTestLog(),
+		JwksRefreshInterval: time.Hour,
+		jwks:                newTestJWKS(t, generateTestKey(t)),
+		rootCertPool:        x509.NewCertPool(),
+		k8sClientSet:        clientset,
+	}
+	s.jwksLastRefresh.Store(time.Now())
+	return s, apiServer
+}
+
+func TestCheckHealth(t *testing.T) {
+	tests := []struct {
+		name          string
+		modify        func(s *TlsBootstrapServer, apiServer *httptest.Server)
+		expectedError string
+	}{
+		{
+			name:   "healthy",
+			modify: func(s *TlsBootstrapServer, apiServer *httptest.Server) {},
+		},
+		{
+			name: "JWKS refreshed within two intervals",
+			modify: func(s *TlsBootstrapServer, apiServer *httptest.Server) {
+				s.jwksLastRefresh.Store(time.Now().Add(-90 * time.Minute))
+			},
+		},
+		{
+			name: "JWKS stale",
+			modify: func(s *TlsBootstrapServer, apiServer *httptest.Server) {
+				s.jwksLastRefresh.Store(time.Now().Add(-3 * time.Hour))
+			},
+			expectedError: "JWKS has not been refreshed",
+		},
+		{
+			name: "JWKS not loaded",
+			modify: func(s *TlsBootstrapServer, apiServer *httptest.Server) {
+				s.jwks = nil
+			},
+			expectedError: "no JWKS keys",
+		},
+		{
+			name: "root certificate pool not loaded",
+			modify: func(s *TlsBootstrapServer, apiServer *httptest.Server) {
+				s.rootCertPool = nil
+			},
+			expectedError: "root certificate pool is not loaded",
+		},
+		{
+			name: "root certificate directory empty",
+			modify: func(s *TlsBootstrapServer, apiServer *httptest.Server) {
+				s.RootCertPath = t.TempDir()
+			},
+			expectedError: "root certificate pool is empty",
+		},
+		{
+			name: "API server unreachable",
+			modify: func(s *TlsBootstrapServer, apiServer *httptest.Server) {
+				apiServer.Close()
+			},
+			expectedError: "API server is unreachable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, apiServer := newHealthyTestServer(t)
+			test.modify(s, apiServer)
+
+			err := s.CheckHealth(context.Background())
+			if test.expectedError == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+				t.Errorf("expected an error containing %q, got %v", test.expectedError, err)
+			}
+		})
+	}
+
+	neverRefreshed := &TlsBootstrapServer{
+		JwksRefreshInterval: time.Hour,
+		jwks:                newTestJWKS(t, generateTestKey(t)),
+		rootCertPool:        x509.NewCertPool(),
+	}
+	if err := neverRefreshed.CheckHealth(context.Background()); err == nil || !strings.Contains(err.Error(), "JWKS has not been refreshed") {
+		t.Errorf("expected a JWKS which was never refreshed to be stale, got %v", err)
+	}
+}
+
+func TestHealthHandlerAndGrpcHealth(t *testing.T) {
+	s, apiServer := newHealthyTestServer(t)
+	healthServer := health.NewServer()
+	handler := s.HealthHandler()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code, recorder.Body.String()
+	}
+	expectProbes := func(readyCode int, readyBody string) {
+		t.Helper()
+		if code, body := get("/healthz"); code != http.StatusOK || body != "ok" {
+			t.Errorf("expected /healthz to always be ok, got %d %q", code, body)
+		}
+		if code, body := get("/readyz"); code != readyCode || !strings.Contains(body, readyBody) {
+			t.Errorf("expected /readyz to return %d %q, got %d %q", readyCode, readyBody, code, body)
+		}
+	}
+	expectGrpcStatus := func(expected healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		for _, service := range []string{"", pb.AKSBootstrapTokenRequest_ServiceDesc.ServiceName} {
+			response, err := healthServer.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+			if err != nil {
+				t.Fatalf("unexpected error checking service %q: %v", service, err)
+			}
+			if response.Status != expected {
+				t.Errorf("expected service %q to be %s, got %s", service, expected, response.Status)
+			}
+		}
+	}
+
+	expectProbes(http.StatusServiceUnavailable, "health has not been checked yet")
+
+	s.updateHealth(healthServer)
+	expectProbes(http.StatusOK, "ok")
+	expectGrpcStatus(healthpb.HealthCheckResponse_SERVING)
+
+	s.jwksLastRefresh.Store(time.Now().Add(-3 * time.Hour))
+	s.updateHealth(healthServer)
+	expectProbes(http.StatusServiceUnavailable, "JWKS has not been refreshed")
+	expectGrpcStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	s.jwksLastRefresh.Store(time.Now())
+	s.updateHealth(healthServer)
+	expectProbes(http.StatusOK, "ok")
+	expectGrpcStatus(healthpb.HealthCheckResponse_SERVING)
+
+	apiServer.Close()
+	s.updateHealth(healthServer)
+	expectProbes(http.StatusServiceUnavailable, "API server is unreachable")
+	expectGrpcStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+}
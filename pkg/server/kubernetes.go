@@ -2,58 +2,122 @@ package server
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
-	"math/rand"
-	"os"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/Azure/aks-tls-bootstrap/pkg/kubeclient"
+	"github.com/Azure/aks-tls-bootstrap/pkg/redact"
 	"github.com/sirupsen/logrus"
 	coreV1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
-func (s *TlsBootstrapServer) createBootstrapToken(vmName string) (string, string, error) {
-	rand.Seed(time.Now().Unix())
+const (
+	// BOOTSTRAP_TOKEN_ISSUER_LABEL marks bootstrap token secrets created by this server,
+	// so that only those are garbage collected.
+	BOOTSTRAP_TOKEN_ISSUER_LABEL = "kubernetes.azure.com/tls-bootstrap-issuer"
+	BOOTSTRAP_TOKEN_ISSUER       = "aks-tls-bootstrap-server"
+
+	// labels and annotations recording what a bootstrap token was issued for
+	BOOTSTRAP_TOKEN_NODE_POOL_LABEL         = "kubernetes.azure.com/tls-bootstrap-node-pool"
+	BOOTSTRAP_TOKEN_SUBSCRIPTION_LABEL      = "kubernetes.azure.com/tls-bootstrap-subscription-id"
+	BOOTSTRAP_TOKEN_HOSTNAME_ANNOTATION     = "kubernetes.azure.com/tls-bootstrap-hostname"
+	BOOTSTRAP_TOKEN_NODE_POOL_ANNOTATION    = "kubernetes.azure.com/tls-bootstrap-node-pool"
+	BOOTSTRAP_TOKEN_RESOURCE_ID_ANNOTATION  = "kubernetes.azure.com/tls-bootstrap-resource-id"
+	BOOTSTRAP_TOKEN_VM_ID_ANNOTATION        = "kubernetes.azure.com/tls-bootstrap-vm-id"
+	BOOTSTRAP_TOKEN_SUBSCRIPTION_ANNOTATION = "kubernetes.azure.com/tls-bootstrap-subscription-id"
+	BOOTSTRAP_TOKEN_CALLER_OID_ANNOTATION   = "kubernetes.azure.com/tls-bootstrap-caller-oid"
+	BOOTSTRAP_TOKEN_ISSUED_AT_ANNOTATION    = "kubernetes.azure.com/tls-bootstrap-issued-at"
+
+	// BOOTSTRAP_TOKEN_CREATE_ATTEMPTS bounds how often a new token id is generated when
+	// the secret for the previous one already exists.
+	BOOTSTRAP_TOKEN_CREATE_ATTEMPTS = 5
+
+	bootstrapTokenSecretPrefix = "bootstrap-token-"
+)
 
-	bootstrapTokenBytes := make([]byte, 3)
-	_, err := rand.Read(bootstrapTokenBytes)
+// bootstrapTokenRegexp matches the kubeadm bootstrap token format, [a-z0-9]{6}.[a-z0-9]{16}
+var bootstrapTokenRegexp = regexp.MustCompile(`^([a-z0-9]{6})\.([a-z0-9]{16})$`)
+
+func (s *TlsBootstrapServer) createBootstrapToken(vmName string) (string, string, error) {
+	bootstrapToken, err := randomString(6, bootstrapTokenAlphabet)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate random token for bootstrap token")
+		return "", "", fmt.Errorf("failed to generate random token for bootstrap token: %v", err)
 	}
-	bootstrapToken := hex.EncodeToString(bootstrapTokenBytes)
 
-	bootstrapTokenSecretBytes := make([]byte, 8)
-	_, err = rand.Read(bootstrapTokenSecretBytes)
+	bootstrapTokenSecret, err := randomString(16, bootstrapTokenAlphabet)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate random token for bootstrap secret")
+		return "", "", fmt.Errorf("failed to generate random token for bootstrap secret: %v", err)
+	}
+
+	if !bootstrapTokenRegexp.MatchString(bootstrapToken + "." + bootstrapTokenSecret) {
+		return "", "", fmt.Errorf("generated bootstrap token does not match format %s", bootstrapTokenRegexp.String())
 	}
-	bootstrapTokenSecret := hex.EncodeToString(bootstrapTokenSecretBytes)
 
 	return bootstrapToken, bootstrapTokenSecret, nil
 }
 
-func (s *TlsBootstrapServer) createBootstrapTokenSecret(vmName string) (string, string, error) {
-	expirationDate := time.Now().UTC().Add(TOKEN_LIFETIME).Format(time.RFC3339)
+func (s *TlsBootstrapServer) createBootstrapTokenSecret(request *Request) (string, string, error) {
+	issuedAt := time.Now().UTC()
+	expirationDate := issuedAt.Add(s.TokenLifetime).Format(time.RFC3339)
 
-	bootstrapToken, bootstrapTokenSecret, err := s.createBootstrapToken(vmName)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to generate bootstrap token secret")
+	// a token id is only six characters, so a collision with a token issued for another
+	// node is unlikely but possible. That token must never be overwritten; generate a
+	// new token id and try again instead.
+	for attempt := 1; ; attempt++ {
+		bootstrapToken, bootstrapTokenSecret, err := s.createBootstrapToken(request.VmName)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to generate bootstrap token secret: %w", err)
+		}
+
+		secret := newBootstrapTokenSecret(request, bootstrapToken, bootstrapTokenSecret, issuedAt, expirationDate)
+		s.Log.WithFields(logrus.Fields{
+			"name":        secret.Name,
+			"token":       redact.BootstrapToken(bootstrapToken + "." + bootstrapTokenSecret),
+			"annotations": secret.Annotations,
+			"data":        redact.Map(secret.StringData),
+		}).Debug("bootstrap secret generated")
+
+		_, err = s.kubeSystemSecretsClient.Create(context.Background(), secret, metaV1.CreateOptions{})
+		if err == nil {
+			return bootstrapToken + "." + bootstrapTokenSecret, expirationDate, nil
+		}
+		if !errors.IsAlreadyExists(err) {
+			return "", "", fmt.Errorf("failed to create secret in kube-system namespace: %w", err)
+		}
+		if attempt >= BOOTSTRAP_TOKEN_CREATE_ATTEMPTS {
+			return "", "", fmt.Errorf("failed to create secret in kube-system namespace: token id collided %d times: %w", attempt, err)
+		}
+		s.Log.WithField("name", secret.Name).Warn("bootstrap token id already in use, generating a new one")
 	}
+}
 
-	secret := *&coreV1.Secret{
+// newBootstrapTokenSecret returns the kube-system secret backing the bootstrap token
+// issued for request.
+func newBootstrapTokenSecret(request *Request, bootstrapToken, bootstrapTokenSecret string, issuedAt time.Time, expirationDate string) *coreV1.Secret {
+	secret := &coreV1.Secret{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name: "bootstrap-token-" + bootstrapToken,
+			Name: bootstrapTokenSecretPrefix + bootstrapToken,
+			Labels: map[string]string{
+				BOOTSTRAP_TOKEN_ISSUER_LABEL: BOOTSTRAP_TOKEN_ISSUER,
+			},
 			Annotations: map[string]string{
-				"kubernetes.azure.com/tls-bootstrap-hostname": vmName,
+				BOOTSTRAP_TOKEN_HOSTNAME_ANNOTATION:     request.VmName,
+				BOOTSTRAP_TOKEN_RESOURCE_ID_ANNOTATION:  request.ResourceId,
+				BOOTSTRAP_TOKEN_VM_ID_ANNOTATION:        request.VmId,
+				BOOTSTRAP_TOKEN_SUBSCRIPTION_ANNOTATION: request.SubscriptionId,
+				BOOTSTRAP_TOKEN_CALLER_OID_ANNOTATION:   request.CallerOid,
+				BOOTSTRAP_TOKEN_ISSUED_AT_ANNOTATION:    issuedAt.Format(time.RFC3339),
 			},
 		},
 		Type: coreV1.SecretTypeBootstrapToken,
 		StringData: map[string]string{
+			"description":                    fmt.Sprintf("Bootstrap token issued by %s for %s (%s)", BOOTSTRAP_TOKEN_ISSUER, request.VmName, request.ResourceId),
 			"token-id":                       bootstrapToken,
 			"token-secret":                   bootstrapTokenSecret,
 			"usage-bootstrap-authentication": "true",
@@ -61,48 +125,24 @@ func (s *TlsBootstrapServer) createBootstrapTokenSecret(vmName string) (string,
 			"expiration":                     expirationDate,
 		},
 	}
-	s.Log.WithField("secret", secret).Debug("bootstrap secret generated")
-
-	// TODO(ace): convert this to json patch not create/update retry
-	_, err = s.kubeSystemSecretsClient.Create(context.Background(), &secret, metaV1.CreateOptions{})
-	if err != nil && !errors.IsAlreadyExists(err) {
-		return "", "", fmt.Errorf("failed to create secret in kube-system namespace: %v", err)
+	// labels are only set when the value is a valid label value, the annotations
+	// always hold the full value.
+	if request.SubscriptionId != "" && len(validation.IsValidLabelValue(request.SubscriptionId)) == 0 {
+		secret.Labels[BOOTSTRAP_TOKEN_SUBSCRIPTION_LABEL] = strings.ToLower(request.SubscriptionId)
 	}
-
-	if errors.IsAlreadyExists(err) {
-		s.Log.Info("secret existed, update.")
-		_, err = s.kubeSystemSecretsClient.Update(context.Background(), &secret, metaV1.UpdateOptions{})
-		if err != nil {
-			return "", "", fmt.Errorf("failed to update secret in kube-system namespace: %v", err)
+	if request.NodePool != "" {
+		secret.Annotations[BOOTSTRAP_TOKEN_NODE_POOL_ANNOTATION] = request.NodePool
+		if len(validation.IsValidLabelValue(request.NodePool)) == 0 {
+			secret.Labels[BOOTSTRAP_TOKEN_NODE_POOL_LABEL] = request.NodePool
 		}
 	}
-
-	return bootstrapToken + "." + bootstrapTokenSecret, expirationDate, nil
+	return secret
 }
 
 func (s *TlsBootstrapServer) initializeClient() error {
-	kubeconfig, err := config.GetConfig()
-	if err != nil {
-		return fmt.Errorf("failed to initialize kubernetes client: %v", err)
-	}
-
-	clientset, err := kubernetes.NewForConfig(kubeconfig)
-	if err != nil {
-		return fmt.Errorf("failed to create clientset: %v", err)
-	}
-
-	kcSecret, err := clientset.CoreV1().Secrets(os.Getenv("POD_NS")).Get(context.Background(), "kubeconfig-file", metaV1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("getting kubeconfig secret: %s", err)
-	}
-
-	if kcSecret.Data == nil {
-		return fmt.Errorf("kubeconfig secret is empty")
-	}
-
-	cfg, err := clientcmd.RESTConfigFromKubeConfig(kcSecret.Data["kubeconfig.yaml"])
+	cfg, err := kubeclient.GetConfig(s.KubeClient)
 	if err != nil {
-		return fmt.Errorf("parsing overlay kubeconfig: %s", err)
+		return err
 	}
 
 	overlay, err := kubernetes.NewForConfig(cfg)
@@ -112,12 +152,12 @@ func (s *TlsBootstrapServer) initializeClient() error {
 
 	s.k8sClientSet = overlay
 
-	serverVersion, err := s.k8sClientSet.ServerVersion()
+	serverVersion, err := s.k8sClientSet.Discovery().ServerVersion()
 	if err != nil {
 		return fmt.Errorf("failed to create clientset: %v", err)
 	}
 	s.Log.WithFields(logrus.Fields{
-		"apiServer":     kubeconfig.Host,
+		"apiServer":     cfg.Host,
 		"serverVersion": serverVersion.String(),
 	}).Info("connected to API server")
 
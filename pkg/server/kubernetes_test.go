@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// failCreates makes the first n secret creates fail as if the secret already existed,
+// and records the name of every secret create.
+func failCreates(clientset *fake.Clientset, n int) *[]string {
+	var names []string
+	clientset.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		secret := action.(k8stesting.CreateAction).GetObject().(*coreV1.Secret)
+		names = append(names, secret.Name)
+		if len(names) <= n {
+			return true, nil, errors.NewAlreadyExists(action.GetResource().GroupResource(), secret.Name)
+		}
+		return false, nil, nil
+	})
+	return &names
+}
+
+func TestCreateBootstrapTokenSecretRetriesTokenIdCollision(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	names := failCreates(clientset, 1)
+	s := &TlsBootstrapServer{
+		Log:                     newTestLog(),
+		kubeSystemSecretsClient: clientset.CoreV1().Secrets(metaV1.NamespaceSystem),
+	}
+
+	token, _, err := s.createBootstrapTokenSecret(&Request{VmName: "vm"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(*names) != 2 || (*names)[0] == (*names)[1] {
+		t.Fatalf("expected a second create with a new token id, got creates %v", *names)
+	}
+	if expected := bootstrapTokenSecretPrefix + token[:6]; (*names)[1] != expected {
+		t.Errorf("expected the returned token to belong to secret %s, got %s", (*names)[1], expected)
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("expected an existing secret never to be updated")
+		}
+	}
+}
+
+func TestCreateBootstrapTokenSecretGivesUpAfterCollisions(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	names := failCreates(clientset, BOOTSTRAP_TOKEN_CREATE_ATTEMPTS)
+	s := &TlsBootstrapServer{
+		Log:                     newTestLog(),
+		kubeSystemSecretsClient: clientset.CoreV1().Secrets(metaV1.NamespaceSystem),
+	}
+
+	_, _, err := s.createBootstrapTokenSecret(&Request{VmName: "vm"})
+	if !errors.IsAlreadyExists(err) {
+		t.Fatalf("expected an AlreadyExists error after %d collisions, got %v", BOOTSTRAP_TOKEN_CREATE_ATTEMPTS, err)
+	}
+	if len(*names) != BOOTSTRAP_TOKEN_CREATE_ATTEMPTS {
+		t.Errorf("expected %d creates, got %d", BOOTSTRAP_TOKEN_CREATE_ATTEMPTS, len(*names))
+	}
+}
+
+func TestCreateBootstrapTokenSecretLabelsAndAnnotations(t *testing.T) {
+	request := &Request{
+		ResourceId:     testVmResourceId,
+		SubscriptionId: strings.ToUpper(testSubscriptionId),
+		CallerOid:      testCallerOid,
+		VmId:           testVmId,
+		VmName:         "aks-nodepool1-12345678-vmss_0",
+		NodePool:       "nodepool1",
+	}
+	invalidNodePool := *request
+	invalidNodePool.NodePool = "node pool/1"
+	noNodePool := *request
+	noNodePool.NodePool = ""
+	invalidSubscription := *request
+	invalidSubscription.SubscriptionId = "not/a-label"
+
+	tests := []struct {
+		name                string
+		request             *Request
+		expectedLabels      map[string]string
+		expectedAnnotations map[string]string
+	}{
+		{
+			name:    "valid label values",
+			request: request,
+			expectedLabels: map[string]string{
+				BOOTSTRAP_TOKEN_ISSUER_LABEL:       BOOTSTRAP_TOKEN_ISSUER,
+				BOOTSTRAP_TOKEN_SUBSCRIPTION_LABEL: testSubscriptionId,
+				BOOTSTRAP_TOKEN_NODE_POOL_LABEL:    "nodepool1",
+			},
+			expectedAnnotations: map[string]string{
+				BOOTSTRAP_TOKEN_SUBSCRIPTION_ANNOTATION: strings.ToUpper(testSubscriptionId),
+				BOOTSTRAP_TOKEN_NODE_POOL_ANNOTATION:    "nodepool1",
+			},
+		},
+		{
+			name:    "invalid node pool label value",
+			request: &invalidNodePool,
+			expectedLabels: map[string]string{
+				BOOTSTRAP_TOKEN_ISSUER_LABEL:       BOOTSTRAP_TOKEN_ISSUER,
+				BOOTSTRAP_TOKEN_SUBSCRIPTION_LABEL: testSubscriptionId,
+			},
+			expectedAnnotations: map[string]string{
+				BOOTSTRAP_TOKEN_SUBSCRIPTION_ANNOTATION: strings.ToUpper(testSubscriptionId),
+				BOOTSTRAP_TOKEN_NODE_POOL_ANNOTATION:    "node pool/1",
+			},
+		},
+		{
+			name:    "invalid subscription label value",
+			request: &invalidSubscription,
+			expectedLabels: map[string]string{
+				BOOTSTRAP_TOKEN_ISSUER_LABEL:    BOOTSTRAP_TOKEN_ISSUER,
+				BOOTSTRAP_TOKEN_NODE_POOL_LABEL: "nodepool1",
+			},
+			expectedAnnotations: map[string]string{
+				BOOTSTRAP_TOKEN_SUBSCRIPTION_ANNOTATION: "not/a-label",
+				BOOTSTRAP_TOKEN_NODE_POOL_ANNOTATION:    "nodepool1",
+			},
+		},
+		{
+			name:    "no node pool",
+			request: &noNodePool,
+			expectedLabels: map[string]string{
+				BOOTSTRAP_TOKEN_ISSUER_LABEL:       BOOTSTRAP_TOKEN_ISSUER,
+				BOOTSTRAP_TOKEN_SUBSCRIPTION_LABEL: testSubscriptionId,
+			},
+			expectedAnnotations: map[string]string{
+				BOOTSTRAP_TOKEN_SUBSCRIPTION_ANNOTATION: strings.ToUpper(testSubscriptionId),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			secrets := clientset.CoreV1().Secrets(metaV1.NamespaceSystem)
+			s := &TlsBootstrapServer{
+				Log:                     newTestLog(),
+				TokenLifetime:           time.Minute,
+				kubeSystemSecretsClient: secrets,
+			}
+
+			token, expiration, err := s.createBootstrapTokenSecret(test.request)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tokenId, tokenSecret, _ := strings.Cut(token, ".")
+			secret, err := secrets.Get(context.Background(), bootstrapTokenSecretPrefix+tokenId, metaV1.GetOptions{})
+			if err != nil {
+				t.Fatalf("expected the bootstrap token secret to be created: %v", err)
+			}
+
+			if len(secret.Labels) != len(test.expectedLabels) {
+				t.Errorf("expected labels %v, got %v", test.expectedLabels, secret.Labels)
+			}
+			for key, value := range test.expectedLabels {
+				if secret.Labels[key] != value {
+					t.Errorf("expected label %s=%q, got %q", key, value, secret.Labels[key])
+				}
+			}
+
+			expectedAnnotations := map[string]string{
+				BOOTSTRAP_TOKEN_HOSTNAME_ANNOTATION:    test.request.VmName,
+				BOOTSTRAP_TOKEN_RESOURCE_ID_ANNOTATION: test.request.ResourceId,
+				BOOTSTRAP_TOKEN_VM_ID_ANNOTATION:       test.request.VmId,
+				BOOTSTRAP_TOKEN_CALLER_OID_ANNOTATION:  test.request.CallerOid,
+			}
+			for key, value := range test.expectedAnnotations {
+				expectedAnnotations[key] = value
+			}
+			if _, err := time.Parse(time.RFC3339, secret.Annotations[BOOTSTRAP_TOKEN_ISSUED_AT_ANNOTATION]); err != nil {
+				t.Errorf("expected an issued at annotation, got %v", err)
+			}
+			if len(secret.Annotations) != len(expectedAnnotations)+1 {
+				t.Errorf("expected annotations %v, got %v", expectedAnnotations, secret.Annotations)
+			}
+			for key, value := range expectedAnnotations {
+				if secret.Annotations[key] != value {
+					t.Errorf("expected annotation %s=%q, got %q", key, value, secret.Annotations[key])
+				}
+			}
+
+			if secret.Type != coreV1.SecretTypeBootstrapToken {
+				t.Errorf("expected secret type %s, got %s", coreV1.SecretTypeBootstrapToken, secret.Type)
+			}
+			if secret.StringData["token-id"] != tokenId || secret.StringData["token-secret"] != tokenSecret || secret.StringData["expiration"] != expiration {
+				t.Errorf("expected the secret to hold the returned token and expiration, got %v", secret.StringData)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const METRICS_NAMESPACE = "tls_bootstrap"
+
+// validation stages reported by the validation_total metric
+const (
+	STAGE_JWT       = "jwt"
+	STAGE_PKCS7     = "pkcs7"
+	STAGE_NONCE     = "nonce"
+	STAGE_ARM       = "arm"
+	STAGE_ADMISSION = "admission"
+	STAGE_SECRET    = "secret"
+)
+
+const (
+	RESULT_SUCCESS = "success"
+	// RESULT_UNKNOWN is reported for failures which carry no ErrorInfo reason.
+	RESULT_UNKNOWN = "UNKNOWN"
+)
+
+var (
+	noncesIssued = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "nonces_issued_total",
+		Help:      "Number of nonces issued.",
+	})
+	tokensIssued = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "tokens_issued_total",
+		Help:      "Number of bootstrap tokens issued.",
+	})
+	validationTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "validation_total",
+		Help:      "Outcomes of each request validation stage, by stage and result. Failures are reported by error reason.",
+	}, []string{"stage", "result"})
+	armRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "arm_request_duration_seconds",
+		Help:      "Latency of virtual machine lookups against ARM, by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+	intermediateCertificateFetches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "intermediate_certificate_fetches_total",
+		Help:      "Number of intermediate certificates retrieved for attested data validation, by result.",
+	}, []string{"result"})
+	liveNonces = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "live_nonces",
+		Help:      "Number of nonces in the nonce store which have not yet been used or expired, as of the last expiration check. With a shared nonce store every replica reports the same value.",
+	})
+)
+
+// resultLabel returns RESULT_SUCCESS for a nil error and the error's reason otherwise.
+func resultLabel(err error) string {
+	if err == nil {
+		return RESULT_SUCCESS
+	}
+	reason := ErrorReason(err)
+	if reason == "" {
+		return RESULT_UNKNOWN
+	}
+	return reason
+}
+
+func observeStage(stage string, err error) {
+	validationTotal.WithLabelValues(stage, resultLabel(err)).Inc()
+}
+
+// MetricsHandler serves the Prometheus metrics of the server.
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
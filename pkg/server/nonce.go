@@ -2,36 +2,45 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
 	"time"
 
 	pb "github.com/Azure/aks-tls-bootstrap/pkg/proto"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"google.golang.org/grpc/codes"
 )
 
 func (s *TlsBootstrapServer) removeExpiredNonces() {
-	interval := NONCE_EXPIRATION_CHECK_INTERVAL
+	interval := s.NonceExpirationCheckInterval
 
 	s.Log.Infof("starting nonce expiration checker, interval %d second(s)", interval/time.Second)
 	ticker := time.NewTicker(interval)
 
 	for range ticker.C {
-		for nonce := range s.requests {
-			if s.requests[nonce].Expiration.Before(time.Now()) {
-				s.Log.Infof("removing expired nonce %s for %s", nonce, s.requests[nonce].ResourceId)
-				delete(s.requests, nonce)
-			}
+		expired, err := s.NonceStore.Expire(time.Now())
+		if err != nil {
+			s.Log.WithError(err).Error("failed to remove expired nonces")
+		}
+		for _, request := range expired {
+			s.Log.Infof("removing expired nonce %s for %s", request.Nonce, request.ResourceId)
+		}
+
+		live, err := s.NonceStore.Len()
+		if err != nil {
+			s.Log.WithError(err).Error("failed to count live nonces")
+			continue
 		}
+		liveNonces.Set(float64(live))
 	}
 }
 
 func generateNonceString() (string, error) {
-	rand.Seed(time.Now().Unix())
-	bytes := make([]byte, 5)
+	bytes := make([]byte, NONCE_LENGTH/2)
 	_, err := rand.Read(bytes)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate random token for nonce")
+		return "", fmt.Errorf("failed to generate random token for nonce: %v", err)
 	}
 	return hex.EncodeToString(bytes), nil
 }
@@ -40,34 +49,55 @@ func (s *TlsBootstrapServer) GetNonce(ctx context.Context, nonceRequest *pb.Nonc
 	requestLog := s.Log.WithField("resourceId", nonceRequest.ResourceId)
 	requestLog.Infof("received nonce request")
 
+	tokenClaims, err := tokenClaimsFromContext(ctx)
+	if err != nil {
+		err = newError(codes.Unauthenticated, REASON_INVALID_TOKEN, "%v", err)
+		requestLog.Error(err)
+		return nil, err
+	}
+	requestLog = requestLog.WithField("oid", tokenClaims.Oid)
+
+	resourceId, err := arm.ParseResourceID(nonceRequest.ResourceId)
+	if err != nil {
+		err = newError(codes.InvalidArgument, REASON_INVALID_RESOURCE_ID, "failed to parse resourceId: %v", err)
+		requestLog.Error(err)
+		return nil, err
+	}
+
 	var nonceStr string
-	var err error
-	attempts := 0
-	for attempts < 100 {
-		attempts++
+	stored := false
+	for attempts := 0; attempts < 100 && !stored; attempts++ {
 		nonceStr, err = generateNonceString()
 		if err != nil {
+			err = newError(codes.Internal, REASON_NONCE_GENERATION_FAILED, "%v", err)
+			requestLog.Error(err)
 			return nil, err
 		}
-		_, exists := s.requests[nonceStr]
-		if !exists {
-			break
+
+		err = s.NonceStore.Put(&Request{
+			Nonce:          nonceStr,
+			ResourceId:     nonceRequest.ResourceId,
+			SubscriptionId: resourceId.SubscriptionID,
+			CallerOid:      tokenClaims.Oid,
+			Expiration:     time.Now().Add(s.NonceLifetime),
+		})
+		if err == nil {
+			stored = true
+		} else if err != ErrNonceExists {
+			err = newError(codes.Unavailable, REASON_NONCE_STORE_UNAVAILABLE, "failed to store nonce: %v", err)
+			requestLog.Error(err)
+			return nil, err
 		}
 	}
-	if attempts == 100 {
-		err := fmt.Errorf("unable to generate a non-colliding nonce after 100 attempts")
+	if !stored {
+		err := newError(codes.Internal, REASON_NONCE_GENERATION_FAILED, "unable to generate a non-colliding nonce after 100 attempts")
 		requestLog.Error(err)
 		return nil, err
 	}
 
+	noncesIssued.Inc()
 	requestLog = requestLog.WithField("nonce", nonceStr)
 
-	s.requests[nonceStr] = &Request{
-		Nonce:      nonceStr,
-		ResourceId: nonceRequest.ResourceId,
-		Expiration: time.Now().Add(NONCE_LIFETIME),
-	}
-
 	requestLog.Info("replying to nonce request")
 	return &pb.NonceResponse{
 		Nonce: nonceStr,
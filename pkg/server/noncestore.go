@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNonceExists   = fmt.Errorf("nonce already exists")
+	ErrNonceNotFound = fmt.Errorf("nonce not found")
+)
+
+// NonceStore holds the outstanding nonce requests between GetNonce and GetToken.
+// Implementations must be safe for concurrent use.
+type NonceStore interface {
+	// Put stores a new request, returning ErrNonceExists if the nonce is already in use.
+	Put(request *Request) error
+	// Get returns a copy of the request for the given nonce, or ErrNonceNotFound.
+	Get(nonce string) (*Request, error)
+	// Consume atomically removes and returns the request for the given nonce, or
+	// ErrNonceNotFound if it does not exist or has already been consumed.
+	Consume(nonce string) (*Request, error)
+	// Expire removes all requests which expired before the given time and returns them.
+	Expire(before time.Time) ([]*Request, error)
+	// Len returns the number of requests currently stored.
+	Len() (int, error)
+}
+
+type memoryNonceStore struct {
+	lock     sync.Mutex
+	requests map[string]*Request
+}
+
+func NewMemoryNonceStore() NonceStore {
+	return &memoryNonceStore{
+		requests: make(map[string]*Request),
+	}
+}
+
+func (m *memoryNonceStore) Put(request *Request) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.requests[request.Nonce]; exists {
+		return ErrNonceExists
+	}
+
+	stored := *request
+	m.requests[request.Nonce] = &stored
+	return nil
+}
+
+func (m *memoryNonceStore) Get(nonce string) (*Request, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	request, exists := m.requests[nonce]
+	if !exists {
+		return nil, ErrNonceNotFound
+	}
+
+	result := *request
+	return &result, nil
+}
+
+func (m *memoryNonceStore) Consume(nonce string) (*Request, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	request, exists := m.requests[nonce]
+	if !exists {
+		return nil, ErrNonceNotFound
+	}
+	delete(m.requests, nonce)
+
+	return request, nil
+}
+
+func (m *memoryNonceStore) Expire(before time.Time) ([]*Request, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	expired := []*Request{}
+	for nonce, request := range m.requests {
+		if request.Expiration.Before(before) {
+			expired = append(expired, request)
+			delete(m.requests, nonce)
+		}
+	}
+
+	return expired, nil
+}
+
+func (m *memoryNonceStore) Len() (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return len(m.requests), nil
+}
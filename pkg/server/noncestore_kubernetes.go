@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coreV1Types "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	nonceConfigMapPrefix        = "tls-bootstrap-nonce-"
+	nonceConfigMapLabel         = "kubernetes.azure.com/tls-bootstrap-nonce"
+	nonceExpirationAnnotation   = "kubernetes.azure.com/tls-bootstrap-nonce-expiration"
+	nonceConfigMapRequestKey    = "request"
+	nonceConfigMapLabelSelector = nonceConfigMapLabel + "=true"
+)
+
+// kubernetesNonceStore persists nonce requests as ConfigMaps so that GetNonce and
+// GetToken may be served by different replicas.
+type kubernetesNonceStore struct {
+	configMapsClient coreV1Types.ConfigMapInterface
+}
+
+func NewKubernetesNonceStore(configMapsClient coreV1Types.ConfigMapInterface) NonceStore {
+	return &kubernetesNonceStore{
+		configMapsClient: configMapsClient,
+	}
+}
+
+func (k *kubernetesNonceStore) Put(request *Request) error {
+	requestJson, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal nonce request: %v", err)
+	}
+
+	configMap := &coreV1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name: nonceConfigMapPrefix + request.Nonce,
+			Labels: map[string]string{
+				nonceConfigMapLabel: "true",
+			},
+			Annotations: map[string]string{
+				nonceExpirationAnnotation: request.Expiration.UTC().Format(time.RFC3339Nano),
+			},
+		},
+		Data: map[string]string{
+			nonceConfigMapRequestKey: string(requestJson),
+		},
+	}
+
+	_, err = k.configMapsClient.Create(context.Background(), configMap, metaV1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return ErrNonceExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create nonce configmap: %v", err)
+	}
+
+	return nil
+}
+
+func (k *kubernetesNonceStore) Get(nonce string) (*Request, error) {
+	configMap, err := k.get(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return requestFromConfigMap(configMap)
+}
+
+func (k *kubernetesNonceStore) Consume(nonce string) (*Request, error) {
+	configMap, err := k.get(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := requestFromConfigMap(configMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// the UID precondition guarantees that only one replica can consume a given nonce.
+	err = k.configMapsClient.Delete(context.Background(), configMap.Name, metaV1.DeleteOptions{
+		Preconditions: metaV1.NewUIDPreconditions(string(configMap.UID)),
+	})
+	if errors.IsNotFound(err) || errors.IsConflict(err) {
+		return nil, ErrNonceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete nonce configmap %s: %v", configMap.Name, err)
+	}
+
+	return request, nil
+}
+
+func (k *kubernetesNonceStore) Expire(before time.Time) ([]*Request, error) {
+	configMaps, err := k.configMapsClient.List(context.Background(), metaV1.ListOptions{
+		LabelSelector: nonceConfigMapLabelSelector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nonce configmaps: %v", err)
+	}
+
+	expired := []*Request{}
+	for i := range configMaps.Items {
+		configMap := &configMaps.Items[i]
+		expiration, err := time.Parse(time.RFC3339Nano, configMap.Annotations[nonceExpirationAnnotation])
+		if err == nil && !expiration.Before(before) {
+			continue
+		}
+
+		// configmaps with a missing or unparseable expiration are removed as well.
+		err = k.configMapsClient.Delete(context.Background(), configMap.Name, metaV1.DeleteOptions{
+			Preconditions: metaV1.NewUIDPreconditions(string(configMap.UID)),
+		})
+		if errors.IsNotFound(err) || errors.IsConflict(err) {
+			// consumed or expired by another replica in the meantime
+			continue
+		}
+		if err != nil {
+			return expired, fmt.Errorf("failed to delete nonce configmap %s: %v", configMap.Name, err)
+		}
+
+		request, err := requestFromConfigMap(configMap)
+		if err != nil {
+			request = &Request{Nonce: strings.TrimPrefix(configMap.Name, nonceConfigMapPrefix)}
+		}
+		expired = append(expired, request)
+	}
+
+	return expired, nil
+}
+
+func (k *kubernetesNonceStore) Len() (int, error) {
+	// resource version 0 allows the API server to answer from its watch cache
+	configMaps, err := k.configMapsClient.List(context.Background(), metaV1.ListOptions{
+		LabelSelector:   nonceConfigMapLabelSelector,
+		ResourceVersion: "0",
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list nonce configmaps: %v", err)
+	}
+
+	return len(configMaps.Items), nil
+}
+
+func (k *kubernetesNonceStore) get(nonce string) (*coreV1.ConfigMap, error) {
+	configMap, err := k.configMapsClient.Get(context.Background(), nonceConfigMapPrefix+nonce, metaV1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, ErrNonceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve nonce configmap: %v", err)
+	}
+
+	return configMap, nil
+}
+
+func requestFromConfigMap(configMap *coreV1.ConfigMap) (*Request, error) {
+	request := &Request{}
+	err := json.Unmarshal([]byte(configMap.Data[nonceConfigMapRequestKey]), request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal nonce request from configmap %s: %v", configMap.Name, err)
+	}
+
+	return request, nil
+}
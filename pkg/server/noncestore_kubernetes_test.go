@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testNonceNamespace = "tls-bootstrap"
+
+// newFakeNonceClientset returns a fake clientset which, like the API server, assigns
+// a UID to every created configmap and enforces UID preconditions on delete.
+func newFakeNonceClientset(t *testing.T) *fake.Clientset {
+	t.Helper()
+
+	clientset := fake.NewSimpleClientset()
+	var uidCounter int64
+
+	clientset.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		configMap := action.(k8stesting.CreateAction).GetObject().(*coreV1.ConfigMap)
+		configMap.UID = types.UID(fmt.Sprintf("uid-%d", atomic.AddInt64(&uidCounter, 1)))
+		return false, nil, nil
+	})
+
+	clientset.PrependReactor("delete", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deleteAction := action.(k8stesting.DeleteAction)
+		preconditions := deleteAction.GetDeleteOptions().Preconditions
+		if preconditions == nil || preconditions.UID == nil {
+			return false, nil, nil
+		}
+
+		obj, err := clientset.Tracker().Get(action.GetResource(), action.GetNamespace(), deleteAction.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		if existing := obj.(*coreV1.ConfigMap); existing.UID != *preconditions.UID {
+			return true, nil, errors.NewConflict(action.GetResource().GroupResource(), deleteAction.GetName(),
+				fmt.Errorf("precondition failed: UID in precondition: %v, UID in object meta: %v", *preconditions.UID, existing.UID))
+		}
+		return false, nil, nil
+	})
+
+	return clientset
+}
+
+func TestKubernetesNonceStorePutCollision(t *testing.T) {
+	clientset := newFakeNonceClientset(t)
+	store := NewKubernetesNonceStore(clientset.CoreV1().ConfigMaps(testNonceNamespace))
+
+	request := &Request{Nonce: "abc", Expiration: time.Now().Add(time.Minute), ResourceId: "first"}
+	if err := store.Put(request); err != nil {
+		t.Fatalf("unexpected error storing nonce: %v", err)
+	}
+
+	err := store.Put(&Request{Nonce: "abc", Expiration: time.Now().Add(time.Minute), ResourceId: "second"})
+	if err != ErrNonceExists {
+		t.Fatalf("expected ErrNonceExists, got %v", err)
+	}
+
+	stored, err := store.Get("abc")
+	if err != nil {
+		t.Fatalf("unexpected error retrieving nonce: %v", err)
+	}
+	if stored.ResourceId != "first" {
+		t.Errorf("colliding Put overwrote the original request: got resource id %q", stored.ResourceId)
+	}
+}
+
+func TestKubernetesNonceStoreConsumeSingleWinner(t *testing.T) {
+	clientset := newFakeNonceClientset(t)
+	store := NewKubernetesNonceStore(clientset.CoreV1().ConfigMaps(testNonceNamespace))
+
+	if err := store.Put(&Request{Nonce: "abc", Expiration: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("unexpected error storing nonce: %v", err)
+	}
+
+	const consumers = 16
+	var winners int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, consumers)
+	for i := 0; i < consumers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			request, err := store.Consume("abc")
+			switch {
+			case err == nil && request.Nonce == "abc":
+				atomic.AddInt64(&winners, 1)
+			case err != ErrNonceNotFound:
+				errs <- err
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("unexpected error consuming nonce: %v", err)
+	}
+	if winners != 1 {
+		t.Errorf("expected exactly one successful Consume, got %d", winners)
+	}
+	if _, err := store.Get("abc"); err != ErrNonceNotFound {
+		t.Errorf("expected consumed nonce to be gone, got %v", err)
+	}
+}
+
+func TestKubernetesNonceStoreConsumeUIDPrecondition(t *testing.T) {
+	clientset := newFakeNonceClientset(t)
+	configMaps := clientset.CoreV1().ConfigMaps(testNonceNamespace)
+	store := NewKubernetesNonceStore(configMaps)
+
+	if err := store.Put(&Request{Nonce: "abc", Expiration: time.Now().Add(time.Minute), ResourceId: "first"}); err != nil {
+		t.Fatalf("unexpected error storing nonce: %v", err)
+	}
+
+	// simulate another replica consuming the nonce and a new request reusing it
+	// between this replica's get and delete.
+	var replaced bool
+	clientset.PrependReactor("delete", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if replaced {
+			return false, nil, nil
+		}
+		replaced = true
+
+		obj, err := clientset.Tracker().Get(action.GetResource(), action.GetNamespace(), nonceConfigMapPrefix+"abc")
+		if err != nil {
+			return true, nil, err
+		}
+		configMap := obj.(*coreV1.ConfigMap).DeepCopy()
+		configMap.UID = "replacement"
+		configMap.Data[nonceConfigMapRequestKey] = `{"Nonce":"abc","ResourceId":"second"}`
+		return false, nil, clientset.Tracker().Update(action.GetResource(), configMap, action.GetNamespace())
+	})
+
+	_, err := store.Consume("abc")
+	if err != ErrNonceNotFound {
+		t.Fatalf("expected ErrNonceNotFound when the UID precondition fails, got %v", err)
+	}
+
+	configMap, err := configMaps.Get(context.Background(), nonceConfigMapPrefix+"abc", metaV1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the replacement configmap to survive, got %v", err)
+	}
+	if configMap.UID != "replacement" {
+		t.Errorf("expected the replacement configmap to survive, got UID %q", configMap.UID)
+	}
+}
+
+func TestKubernetesNonceStoreExpire(t *testing.T) {
+	clientset := newFakeNonceClientset(t)
+	configMaps := clientset.CoreV1().ConfigMaps(testNonceNamespace)
+	store := NewKubernetesNonceStore(configMaps)
+
+	now := time.Now()
+	for _, request := range []*Request{
+		{Nonce: "expired", Expiration: now.Add(-time.Minute)},
+		{Nonce: "current", Expiration: now.Add(time.Minute)},
+	} {
+		if err := store.Put(request); err != nil {
+			t.Fatalf("unexpected error storing nonce %s: %v", request.Nonce, err)
+		}
+	}
+
+	// a configmap without a parseable expiration is treated as expired
+	_, err := configMaps.Create(context.Background(), &coreV1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:   nonceConfigMapPrefix + "corrupt",
+			Labels: map[string]string{nonceConfigMapLabel: "true"},
+		},
+	}, metaV1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error creating configmap: %v", err)
+	}
+
+	// configmaps without the nonce label are never touched
+	_, err = configMaps.Create(context.Background(), &coreV1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{Name: "unrelated"},
+	}, metaV1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error creating configmap: %v", err)
+	}
+
+	expired, err := store.Expire(now)
+	if err != nil {
+		t.Fatalf("unexpected error expiring nonces: %v", err)
+	}
+
+	expiredNonces := map[string]bool{}
+	for _, request := range expired {
+		expiredNonces[request.Nonce] = true
+	}
+	if len(expired) != 2 || !expiredNonces["expired"] || !expiredNonces["corrupt"] {
+		t.Errorf("expected nonces expired and corrupt to be expired, got %v", expiredNonces)
+	}
+
+	if _, err := store.Get("current"); err != nil {
+		t.Errorf("expected unexpired nonce to remain, got %v", err)
+	}
+	if live, err := store.Len(); err != nil || live != 1 {
+		t.Errorf("expected one live nonce, got %d (%v)", live, err)
+	}
+	if _, err := store.Get("expired"); err != ErrNonceNotFound {
+		t.Errorf("expected expired nonce to be removed, got %v", err)
+	}
+	if _, err := configMaps.Get(context.Background(), "unrelated", metaV1.GetOptions{}); err != nil {
+		t.Errorf("expected unrelated configmap to remain, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMemoryNonceStoreConcurrentAccess is meant to be run with -race.
+func TestMemoryNonceStoreConcurrentAccess(t *testing.T) {
+	store := NewMemoryNonceStore()
+
+	const workers = 8
+	const perWorker = 200
+
+	var consumed int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				nonce := fmt.Sprintf("%d-%d", worker, j)
+				expiration := time.Now().Add(time.Minute)
+				if j%2 == 0 {
+					expiration = time.Now().Add(-time.Minute)
+				}
+
+				if err := store.Put(&Request{Nonce: nonce, Expiration: expiration}); err != nil {
+					t.Errorf("unexpected error storing nonce %s: %v", nonce, err)
+					return
+				}
+				// the nonce may already have been expired by another worker
+				if _, err := store.Get(nonce); err != nil && err != ErrNonceNotFound {
+					t.Errorf("unexpected error retrieving nonce %s: %v", nonce, err)
+				}
+				if _, err := store.Expire(time.Now()); err != nil {
+					t.Errorf("unexpected error expiring nonces: %v", err)
+				}
+				if _, err := store.Len(); err != nil {
+					t.Errorf("unexpected error counting nonces: %v", err)
+				}
+				if j%2 == 1 {
+					if _, err := store.Consume(nonce); err != nil {
+						t.Errorf("unexpected error consuming unexpired nonce %s: %v", nonce, err)
+						continue
+					}
+					atomic.AddInt64(&consumed, 1)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if consumed != workers*perWorker/2 {
+		t.Errorf("expected %d consumed nonces, got %d", workers*perWorker/2, consumed)
+	}
+	if live, err := store.Len(); err != nil || live != 0 {
+		t.Errorf("expected no live nonces, got %d (%v)", live, err)
+	}
+}
+
+func TestMemoryNonceStoreConsumeSingleUse(t *testing.T) {
+	store := NewMemoryNonceStore()
+
+	if err := store.Put(&Request{Nonce: "abc", Expiration: time.Now().Add(time.Minute), ResourceId: "first"}); err != nil {
+		t.Fatalf("unexpected error storing nonce: %v", err)
+	}
+	if err := store.Put(&Request{Nonce: "abc", Expiration: time.Now().Add(time.Minute), ResourceId: "second"}); err != ErrNonceExists {
+		t.Fatalf("expected ErrNonceExists, got %v", err)
+	}
+
+	const consumers = 16
+	var winners int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < consumers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			request, err := store.Consume("abc")
+			switch {
+			case err == nil && request.ResourceId == "first":
+				atomic.AddInt64(&winners, 1)
+			case err != ErrNonceNotFound:
+				t.Errorf("unexpected result consuming nonce: %+v, %v", request, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if winners != 1 {
+		t.Errorf("expected exactly one successful Consume, got %d", winners)
+	}
+	if _, err := store.Consume("abc"); err != ErrNonceNotFound {
+		t.Errorf("expected a consumed nonce not to be consumed again, got %v", err)
+	}
+	if _, err := store.Get("abc"); err != ErrNonceNotFound {
+		t.Errorf("expected consumed nonce to be gone, got %v", err)
+	}
+}
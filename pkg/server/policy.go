@@ -0,0 +1,226 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	TAG_MATCH_EXACT  = "exact"
+	TAG_MATCH_PREFIX = "prefix"
+	TAG_MATCH_REGEX  = "regex"
+
+	DEFAULT_NODE_POOL_TAG = "aks-managed-poolName"
+)
+
+// TagRule requires a virtual machine to carry an ARM tag whose value matches.
+type TagRule struct {
+	Key       string
+	MatchType string
+	Value     string
+	regexp    *regexp.Regexp
+}
+
+// NewTagRule validates a tag rule, compiling its value if it is a regular expression.
+// Regular expressions must match the entire tag value.
+func NewTagRule(key string, matchType string, value string) (*TagRule, error) {
+	if key == "" {
+		return nil, fmt.Errorf("tag rule has no tag name")
+	}
+
+	rule := &TagRule{
+		Key:       key,
+		MatchType: strings.ToLower(matchType),
+		Value:     value,
+	}
+
+	switch rule.MatchType {
+	case TAG_MATCH_EXACT, TAG_MATCH_PREFIX:
+	case TAG_MATCH_REGEX:
+		// anchor the expression so that it must match the whole tag value, as an
+		// unanchored expression such as "prod" would also admit "not-prod".
+		var err error
+		rule.regexp, err = regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("tag rule for %s has invalid regular expression %q: %v", key, value, err)
+		}
+	default:
+		return nil, fmt.Errorf("tag rule for %s has unknown match type %q, expected %s, %s or %s", key, matchType, TAG_MATCH_EXACT, TAG_MATCH_PREFIX, TAG_MATCH_REGEX)
+	}
+
+	return rule, nil
+}
+
+// ParseTagRule parses a rule in the form <tag>=<match type>:<value>, for example
+// aks-managed-poolName=prefix:nodepool. If the match type is omitted, the value
+// must match exactly.
+func ParseTagRule(spec string) (*TagRule, error) {
+	key, value, found := strings.Cut(spec, "=")
+	if !found {
+		return nil, fmt.Errorf("tag rule %q is not in the form <tag>=<match type>:<value>", spec)
+	}
+
+	matchType := TAG_MATCH_EXACT
+	if prefix, rest, found := strings.Cut(value, ":"); found {
+		switch strings.ToLower(prefix) {
+		case TAG_MATCH_EXACT, TAG_MATCH_PREFIX, TAG_MATCH_REGEX:
+			matchType = prefix
+			value = rest
+		}
+	}
+
+	return NewTagRule(strings.TrimSpace(key), matchType, value)
+}
+
+func (r *TagRule) String() string {
+	return fmt.Sprintf("%s=%s:%s", r.Key, r.MatchType, r.Value)
+}
+
+// Matches returns true if the tags contain the rule's tag with a matching value.
+func (r *TagRule) Matches(tags map[string]string) bool {
+	value, ok := lookupTag(tags, r.Key)
+	if !ok {
+		return false
+	}
+
+	switch r.MatchType {
+	case TAG_MATCH_EXACT:
+		return value == r.Value
+	case TAG_MATCH_PREFIX:
+		return strings.HasPrefix(value, r.Value)
+	case TAG_MATCH_REGEX:
+		return r.regexp != nil && r.regexp.MatchString(value)
+	}
+
+	return false
+}
+
+// AdmissionPolicy restricts which virtual machines may be issued a bootstrap token.
+// An empty allowlist does not restrict that property.
+type AdmissionPolicy struct {
+	AllowedSubscriptionIds []string
+	AllowedResourceGroups  []string
+	AllowedVmssNames       []string
+	RequiredTags           []*TagRule
+	NodePoolTag            string
+}
+
+// Admit returns an error if the virtual machine is not allowed to join by the policy.
+func (p *AdmissionPolicy) Admit(subscriptionId string, vm *VirtualMachine) error {
+	if !allowedByList(p.AllowedSubscriptionIds, subscriptionId) {
+		return fmt.Errorf("subscription %s is not in allowed subscription list", subscriptionId)
+	}
+
+	if !allowedByList(p.AllowedResourceGroups, vm.ResourceGroup) {
+		return fmt.Errorf("resource group %s is not in allowed resource group list", vm.ResourceGroup)
+	}
+
+	if !allowedByList(p.AllowedVmssNames, vm.VmssName) {
+		if vm.VmssName == "" {
+			return fmt.Errorf("virtual machine %s is not part of a scale set and a scale set allowlist is configured", vm.Name)
+		}
+		return fmt.Errorf("scale set %s is not in allowed scale set list", vm.VmssName)
+	}
+
+	for _, rule := range p.RequiredTags {
+		if !rule.Matches(vm.Tags) {
+			return fmt.Errorf("virtual machine %s does not carry a tag matching %s", vm.Name, rule.String())
+		}
+	}
+
+	return nil
+}
+
+// NodePool returns the value of the node pool tag on the virtual machine, if any.
+func (p *AdmissionPolicy) NodePool(vm *VirtualMachine) string {
+	nodePoolTag := p.NodePoolTag
+	if nodePoolTag == "" {
+		nodePoolTag = DEFAULT_NODE_POOL_TAG
+	}
+
+	nodePool, _ := lookupTag(vm.Tags, nodePoolTag)
+	return nodePool
+}
+
+// lookupTag finds a tag by name; ARM tag names are case-insensitive.
+func lookupTag(tags map[string]string, key string) (string, bool) {
+	if value, ok := tags[key]; ok {
+		return value, true
+	}
+
+	for tagKey, value := range tags {
+		if strings.EqualFold(tagKey, key) {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// allowedByList returns true if the list is empty or contains the value. Azure
+// resource names and IDs are compared case-insensitively.
+func allowedByList(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+
+	for _, allowed := range list {
+		if value != "" && strings.EqualFold(allowed, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CallerPolicy allows callers which are not individually allowlisted, by the
+// application they authenticated as, an app role assigned to them or membership
+// of a group. An empty list does not allow anything.
+type CallerPolicy struct {
+	AllowedAppIds   []string
+	AllowedRoles    []string
+	AllowedGroupIds []string
+	// RejectGroupOverage rejects callers whose token omits their groups because
+	// they are a member of too many; otherwise group rules are skipped for them.
+	RejectGroupOverage bool
+}
+
+// Authorize returns a description of the rule which allows the caller, or an empty
+// string if no rule allows it. overage is true if the token's group list was
+// incomplete and group rules could not be evaluated.
+func (p *CallerPolicy) Authorize(tokenClaims *AzureADTokenClaims) (allowedBy string, overage bool, err error) {
+	appId := tokenClaims.AppId
+	if appId == "" {
+		// v2.0 tokens carry the application ID in azp
+		appId = tokenClaims.Azp
+	}
+	if len(p.AllowedAppIds) > 0 && allowedByList(p.AllowedAppIds, appId) {
+		return "appid:" + appId, false, nil
+	}
+
+	if len(p.AllowedRoles) > 0 {
+		for _, role := range tokenClaims.Roles {
+			for _, allowedRole := range p.AllowedRoles {
+				// app role values are case-sensitive
+				if role == allowedRole {
+					return "role:" + role, false, nil
+				}
+			}
+		}
+	}
+
+	if len(p.AllowedGroupIds) > 0 {
+		overage = tokenClaims.HasGroups || tokenClaims.ClaimNames.Groups != ""
+		if overage && p.RejectGroupOverage {
+			return "", true, fmt.Errorf("token for %s has a group overage claim and group membership cannot be verified", tokenClaims.Oid)
+		}
+		for _, group := range tokenClaims.Groups {
+			if allowedByList(p.AllowedGroupIds, group) {
+				return "group:" + group, overage, nil
+			}
+		}
+	}
+
+	return "", overage, nil
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTagRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule    string
+		value   string
+		matches bool
+	}{
+		{"pool=nodepool1", "nodepool1", true},
+		{"pool=nodepool1", "nodepool10", false},
+		{"pool=exact:nodepool1", "nodepool1", true},
+		{"pool=prefix:nodepool", "nodepool10", true},
+		{"pool=prefix:nodepool", "sysnodepool", false},
+		{"pool=regex:prod", "prod", true},
+		{"pool=regex:prod", "not-prod", false},
+		{"pool=regex:prod", "production", false},
+		{"pool=regex:^[a-z0-9]+$", "nodepool1", true},
+		{"pool=regex:^[a-z0-9]+$", "node-pool", false},
+		{"pool=regex:user|system", "system", true},
+		{"pool=regex:user|system", "usersystem", false},
+		{"pool=regex:user|system", "superuser", false},
+		{"pool=regex:nodepool[0-9]*", "nodepool12", true},
+	}
+
+	for _, test := range tests {
+		rule, err := ParseTagRule(test.rule)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", test.rule, err)
+		}
+		if got := rule.Matches(map[string]string{"pool": test.value}); got != test.matches {
+			t.Errorf("%s matching %q = %v, expected %v", test.rule, test.value, got, test.matches)
+		}
+	}
+}
+
+func TestCallerPolicyAuthorize(t *testing.T) {
+	const (
+		allowedApp   = "5555aaaa-5555-5555-5555-555555555555"
+		allowedGroup = "66666666-6666-6666-6666-666666666666"
+		allowedRole  = "Node.Bootstrap"
+	)
+	policy := &CallerPolicy{
+		AllowedAppIds:   []string{allowedApp},
+		AllowedRoles:    []string{allowedRole},
+		AllowedGroupIds: []string{allowedGroup},
+	}
+	overageClaims := func() *AzureADTokenClaims {
+		claims := &AzureADTokenClaims{Oid: testCallerOid}
+		claims.ClaimNames.Groups = "src1"
+		return claims
+	}
+
+	tests := []struct {
+		name              string
+		policy            *CallerPolicy
+		claims            *AzureADTokenClaims
+		expectedAllowedBy string
+		expectedOverage   bool
+		expectErr         bool
+	}{
+		{
+			name:              "appid",
+			policy:            policy,
+			claims:            &AzureADTokenClaims{AppId: allowedApp},
+			expectedAllowedBy: "appid:" + allowedApp,
+		},
+		{
+			name:              "azp when appid is missing",
+			policy:            policy,
+			claims:            &AzureADTokenClaims{Azp: allowedApp},
+			expectedAllowedBy: "appid:" + allowedApp,
+		},
+		{
+			name:   "appid takes precedence over azp",
+			policy: policy,
+			claims: &AzureADTokenClaims{AppId: testCallerOid, Azp: allowedApp},
+		},
+		{
+			name:              "appid in different case",
+			policy:            policy,
+			claims:            &AzureADTokenClaims{AppId: strings.ToUpper(allowedApp)},
+			expectedAllowedBy: "appid:" + strings.ToUpper(allowedApp),
+		},
+		{
+			name:              "role",
+			policy:            policy,
+			claims:            &AzureADTokenClaims{Roles: []string{"Other", allowedRole}},
+			expectedAllowedBy: "role:" + allowedRole,
+		},
+		{
+			name:   "role in different case",
+			policy: policy,
+			claims: &AzureADTokenClaims{Roles: []string{strings.ToLower(allowedRole)}},
+		},
+		{
+			name:              "group",
+			policy:            policy,
+			claims:            &AzureADTokenClaims{Groups: []string{testTenantId, allowedGroup}},
+			expectedAllowedBy: "group:" + allowedGroup,
+		},
+		{
+			name:   "other group",
+			policy: policy,
+			claims: &AzureADTokenClaims{Groups: []string{testTenantId}},
+		},
+		{
+			name:            "hasgroups overage skips group rules",
+			policy:          policy,
+			claims:          &AzureADTokenClaims{HasGroups: true},
+			expectedOverage: true,
+		},
+		{
+			name:            "_claim_names overage skips group rules",
+			policy:          policy,
+			claims:          overageClaims(),
+			expectedOverage: true,
+		},
+		{
+			name:            "hasgroups overage rejected",
+			policy:          &CallerPolicy{AllowedGroupIds: []string{allowedGroup}, RejectGroupOverage: true},
+			claims:          &AzureADTokenClaims{HasGroups: true},
+			expectedOverage: true,
+			expectErr:       true,
+		},
+		{
+			name:            "_claim_names overage rejected",
+			policy:          &CallerPolicy{AllowedGroupIds: []string{allowedGroup}, RejectGroupOverage: true},
+			claims:          overageClaims(),
+			expectedOverage: true,
+			expectErr:       true,
+		},
+		{
+			name:              "app allowed despite rejected overage",
+			policy:            &CallerPolicy{AllowedAppIds: []string{allowedApp}, AllowedGroupIds: []string{allowedGroup}, RejectGroupOverage: true},
+			claims:            &AzureADTokenClaims{AppId: allowedApp, HasGroups: true},
+			expectedAllowedBy: "appid:" + allowedApp,
+		},
+		{
+			name:   "overage ignored without group rules",
+			policy: &CallerPolicy{AllowedAppIds: []string{allowedApp}, RejectGroupOverage: true},
+			claims: &AzureADTokenClaims{HasGroups: true},
+		},
+		{
+			name:   "empty policy allows nothing",
+			policy: &CallerPolicy{},
+			claims: &AzureADTokenClaims{AppId: allowedApp, Roles: []string{allowedRole}, Groups: []string{allowedGroup}},
+		},
+		{
+			name:   "empty claims",
+			policy: policy,
+			claims: &AzureADTokenClaims{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allowedBy, overage, err := test.policy.Authorize(test.claims)
+			if test.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if allowedBy != test.expectedAllowedBy {
+				t.Errorf("expected allowed by %q, got %q", test.expectedAllowedBy, allowedBy)
+			}
+			if overage != test.expectedOverage {
+				t.Errorf("expected overage %v, got %v", test.expectedOverage, overage)
+			}
+		})
+	}
+}
+
+func TestAdmissionPolicyAdmit(t *testing.T) {
+	poolRule, err := ParseTagRule("aks-managed-poolName=prefix:nodepool")
+	if err != nil {
+		t.Fatalf("failed to parse tag rule: %v", err)
+	}
+
+	vmssVm := &VirtualMachine{
+		Name:          "aks-nodepool1-12345678-vmss_0",
+		ResourceGroup: "MC_rg_cluster_eastus",
+		VmssName:      "aks-nodepool1-12345678-vmss",
+		Tags:          map[string]string{"aks-managed-poolName": "nodepool1"},
+	}
+	standaloneVm := &VirtualMachine{
+		Name:          "aks-nodepool1-12345678-0",
+		ResourceGroup: "MC_rg_cluster_eastus",
+		Tags:          map[string]string{"AKS-Managed-PoolName": "nodepool1"},
+	}
+
+	tests := []struct {
+		name           string
+		policy         *AdmissionPolicy
+		subscriptionId string
+		vm             *VirtualMachine
+		admitted       bool
+	}{
+		{
+			name:           "empty policy",
+			policy:         &AdmissionPolicy{},
+			subscriptionId: testSubscriptionId,
+			vm:             standaloneVm,
+			admitted:       true,
+		},
+		{
+			name:           "allowed subscription in different case",
+			policy:         &AdmissionPolicy{AllowedSubscriptionIds: []string{strings.ToUpper(testSubscriptionId)}},
+			subscriptionId: testSubscriptionId,
+			vm:             vmssVm,
+			admitted:       true,
+		},
+		{
+			name:           "subscription not allowed",
+			policy:         &AdmissionPolicy{AllowedSubscriptionIds: []string{testTenantId}},
+			subscriptionId: testSubscriptionId,
+			vm:             vmssVm,
+		},
+		{
+			name:           "empty subscription with allowlist",
+			policy:         &AdmissionPolicy{AllowedSubscriptionIds: []string{testSubscriptionId}},
+			subscriptionId: "",
+			vm:             vmssVm,
+		},
+		{
+			name:           "allowed resource group in different case",
+			policy:         &AdmissionPolicy{AllowedResourceGroups: []string{"mc_rg_cluster_eastus"}},
+			subscriptionId: testSubscriptionId,
+			vm:             vmssVm,
+			admitted:       true,
+		},
+		{
+			name:           "resource group not allowed",
+			policy:         &AdmissionPolicy{AllowedResourceGroups: []string{"other"}},
+			subscriptionId: testSubscriptionId,
+			vm:             vmssVm,
+		},
+		{
+			name:           "allowed scale set",
+			policy:         &AdmissionPolicy{AllowedVmssNames: []string{"AKS-nodepool1-12345678-vmss"}},
+			subscriptionId: testSubscriptionId,
+			vm:             vmssVm,
+			admitted:       true,
+		},
+		{
+			name:           "scale set not allowed",
+			policy:         &AdmissionPolicy{AllowedVmssNames: []string{"aks-nodepool2-12345678-vmss"}},
+			subscriptionId: testSubscriptionId,
+			vm:             vmssVm,
+		},
+		{
+			name:           "standalone vm with scale set allowlist",
+			policy:         &AdmissionPolicy{AllowedVmssNames: []string{"aks-nodepool1-12345678-vmss"}},
+			subscriptionId: testSubscriptionId,
+			vm:             standaloneVm,
+		},
+		{
+			name:           "required tag with key in different case",
+			policy:         &AdmissionPolicy{RequiredTags: []*TagRule{poolRule}},
+			subscriptionId: testSubscriptionId,
+			vm:             standaloneVm,
+			admitted:       true,
+		},
+		{
+			name:           "required tag missing",
+			policy:         &AdmissionPolicy{RequiredTags: []*TagRule{poolRule}},
+			subscriptionId: testSubscriptionId,
+			vm:             &VirtualMachine{Name: "vm", Tags: map[string]string{"poolName": "nodepool1"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.Admit(test.subscriptionId, test.vm)
+			if test.admitted && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.admitted && err == nil {
+				t.Errorf("expected the virtual machine not to be admitted")
+			}
+		})
+	}
+}
+
+func TestAdmissionPolicyNodePool(t *testing.T) {
+	tests := []struct {
+		name        string
+		nodePoolTag string
+		tags        map[string]string
+		expected    string
+	}{
+		{"default tag", "", map[string]string{DEFAULT_NODE_POOL_TAG: "nodepool1"}, "nodepool1"},
+		{"default tag in different case", "", map[string]string{"AKS-MANAGED-POOLNAME": "nodepool1"}, "nodepool1"},
+		{"custom tag", "pool", map[string]string{DEFAULT_NODE_POOL_TAG: "nodepool1", "pool": "custom"}, "custom"},
+		{"custom tag ignores default tag", "pool", map[string]string{DEFAULT_NODE_POOL_TAG: "nodepool1"}, ""},
+		{"no tags", "", nil, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := &AdmissionPolicy{NodePoolTag: test.nodePoolTag}
+			if got := policy.NodePool(&VirtualMachine{Tags: test.tags}); got != test.expected {
+				t.Errorf("expected node pool %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
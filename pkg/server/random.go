@@ -0,0 +1,25 @@
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+const bootstrapTokenAlphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// randomString returns a string of the given length with characters drawn
+// uniformly from alphabet using crypto/rand.
+func randomString(length int, alphabet string) (string, error) {
+	max := big.NewInt(int64(len(alphabet)))
+	result := make([]byte, length)
+	for i := range result {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to read random data: %v", err)
+		}
+		result[i] = alphabet[n.Int64()]
+	}
+
+	return string(result), nil
+}
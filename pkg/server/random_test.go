@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRandomStringFormat(t *testing.T) {
+	for _, length := range []int{0, 1, 6, 16, 64} {
+		value, err := randomString(length, bootstrapTokenAlphabet)
+		if err != nil {
+			t.Fatalf("unexpected error generating string of length %d: %v", length, err)
+		}
+		if len(value) != length {
+			t.Errorf("expected length %d, got %d (%q)", length, len(value), value)
+		}
+		for _, c := range value {
+			if !strings.ContainsRune(bootstrapTokenAlphabet, c) {
+				t.Errorf("character %q in %q is not in the alphabet", c, value)
+			}
+		}
+	}
+
+	value, err := randomString(32, "x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != strings.Repeat("x", 32) {
+		t.Errorf("expected only characters from a single-letter alphabet, got %q", value)
+	}
+}
+
+// kubeadmBootstrapTokenPattern is BootstrapTokenPattern from k8s.io/cluster-bootstrap,
+// which kubeadm and the API server use to recognize bootstrap tokens.
+const kubeadmBootstrapTokenPattern = `\A([a-z0-9]{6})\.([a-z0-9]{16})\z`
+
+// assertConcurrentlyUnique calls generate from several goroutines and fails the test
+// on errors and duplicate values.
+func assertConcurrentlyUnique(t *testing.T, generate func() (string, error)) {
+	t.Helper()
+
+	const workers = 8
+	const perWorker = 500
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	seen := make(map[string]bool, workers*perWorker)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				value, err := generate()
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				lock.Lock()
+				if seen[value] {
+					t.Errorf("duplicate value %q", value)
+				}
+				seen[value] = true
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRandomStringConcurrentUniqueness(t *testing.T) {
+	assertConcurrentlyUnique(t, func() (string, error) {
+		return randomString(16, bootstrapTokenAlphabet)
+	})
+}
+
+func TestGenerateNonceStringConcurrentUniqueness(t *testing.T) {
+	nonceRegexp := regexp.MustCompile(fmt.Sprintf("^[0-9a-f]{%d}$", NONCE_LENGTH))
+	assertConcurrentlyUnique(t, func() (string, error) {
+		nonce, err := generateNonceString()
+		if err == nil && !nonceRegexp.MatchString(nonce) {
+			err = fmt.Errorf("nonce %q is not %d hex characters", nonce, NONCE_LENGTH)
+		}
+		return nonce, err
+	})
+}
+
+func TestCreateBootstrapTokenConcurrentUniqueness(t *testing.T) {
+	s := &TlsBootstrapServer{}
+	kubeadmRegexp := regexp.MustCompile(kubeadmBootstrapTokenPattern)
+	assertConcurrentlyUnique(t, func() (string, error) {
+		token, secret, err := s.createBootstrapToken("vm")
+		if err == nil && !kubeadmRegexp.MatchString(token+"."+secret) {
+			err = fmt.Errorf("token %s.%s does not match the kubeadm format", token, secret)
+		}
+		return token + "." + secret, err
+	})
+}
+
+func TestBootstrapTokenRegexp(t *testing.T) {
+	tests := []struct {
+		token string
+		valid bool
+	}{
+		{"abcdef.0123456789abcdef", true},
+		{"a1b2c3.zzzzzzzzzzzzzzzz", true},
+		{"ABCDEF.0123456789abcdef", false},
+		{"abcde.0123456789abcdef", false},
+		{"abcdefg.0123456789abcdef", false},
+		{"abcdef.0123456789abcde", false},
+		{"abcdef.0123456789abcdef0", false},
+		{"abcdef0123456789abcdef", false},
+		{"abcdef-0123456789abcdef", false},
+		{" abcdef.0123456789abcdef", false},
+		{"abcdef.0123456789abcdef\n", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := bootstrapTokenRegexp.MatchString(test.token); got != test.valid {
+			t.Errorf("bootstrapTokenRegexp.MatchString(%q) = %v, expected %v", test.token, got, test.valid)
+		}
+	}
+}
+
+func TestCreateBootstrapToken(t *testing.T) {
+	s := &TlsBootstrapServer{}
+	kubeadmRegexp := regexp.MustCompile(kubeadmBootstrapTokenPattern)
+	for i := 0; i < 100; i++ {
+		token, secret, err := s.createBootstrapToken("vm")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bootstrapTokenRegexp.MatchString(token+"."+secret) || !kubeadmRegexp.MatchString(token+"."+secret) {
+			t.Fatalf("generated token %s.%s does not match the kubeadm format", token, secret)
+		}
+	}
+}
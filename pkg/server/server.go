@@ -4,20 +4,42 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/Azure/aks-tls-bootstrap/pkg/azure"
 	"github.com/MicahParks/keyfunc"
 	"github.com/sirupsen/logrus"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
-	jwks *keyfunc.JWKS
-	log  *logrus.Logger
+	log *logrus.Logger
 
 	allowedIds []string
 )
 
 func NewServer(s *TlsBootstrapServer) (*TlsBootstrapServer, error) {
-	err := s.initializeClient()
+	err := s.validateLifetimes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.validateClientAuthMode()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.initializeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.AuditKubernetesEvents {
+		s.Log.Info("recording audit events as kubernetes events")
+		s.AuditSink = NewMultiAuditSink(s.AuditSink, NewKubernetesEventAuditSink(s.k8sClientSet.CoreV1().Events(metaV1.NamespaceDefault), metaV1.NamespaceDefault))
+	}
+
+	err = s.watchAllowedIdentities()
 	if err != nil {
 		return nil, err
 	}
@@ -41,19 +63,112 @@ func NewServer(s *TlsBootstrapServer) (*TlsBootstrapServer, error) {
 		},
 	}
 
-	s.requests = make(map[string]*Request)
+	if s.NonceStore == nil {
+		switch s.NonceStoreBackend {
+		case "", NONCE_STORE_MEMORY:
+			s.NonceStore = NewMemoryNonceStore()
+		case NONCE_STORE_KUBERNETES:
+			namespace := s.NonceStoreNamespace
+			if namespace == "" {
+				namespace = "kube-system"
+			}
+			s.Log.WithField("namespace", namespace).Info("storing nonces in kubernetes configmaps")
+			s.NonceStore = NewKubernetesNonceStore(s.k8sClientSet.CoreV1().ConfigMaps(namespace))
+		default:
+			return nil, fmt.Errorf("unknown nonce store backend %q", s.NonceStoreBackend)
+		}
+	}
+
+	if s.Cloud == nil {
+		s.Cloud = azure.AzurePublic
+	}
+	if s.JwksUrl == "" {
+		s.JwksUrl = s.Cloud.JwksUrl()
+	}
+	if len(s.AllowedSigningAlgorithms) == 0 {
+		s.AllowedSigningAlgorithms = []string{DEFAULT_SIGNING_ALGORITHM}
+	}
+	if s.Audience == "" {
+		s.Audience = azure.DEFAULT_AUDIENCE
+	}
+
+	err = s.initializeVMResolver()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize ARM client: %v", err)
+	}
 
-	s.Log.WithField("jwksUrl", s.JwksUrl).Info("fetching Azure AD JWKS keys")
-	jwks, err = keyfunc.Get(s.JwksUrl, keyfunc.Options{
-		Client:          s.httpClient,
-		RefreshInterval: JWKS_REFRESH_INTERVAL,
+	s.Log.WithFields(logrus.Fields{
+		"cloud":   s.Cloud.Name,
+		"jwksUrl": s.JwksUrl,
+	}).Info("fetching Azure AD JWKS keys")
+	s.jwks, err = keyfunc.Get(s.JwksUrl, keyfunc.Options{
+		Client: &http.Client{
+			Transport: &jwksRefreshRecorder{
+				next: s.httpClient.Transport,
+				refreshed: func(t time.Time) {
+					s.jwksLastRefresh.Store(t)
+				},
+			},
+		},
+		RefreshInterval: s.JwksRefreshInterval,
+		RefreshErrorHandler: func(err error) {
+			s.Log.Warnf("failed to refresh JWKS: %v", err)
+		},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to establish jwks keyfunc: %v", err)
 	}
-	s.Log.WithField("KIDs", jwks.KIDs()).Debug("loaded jwks")
+	s.Log.WithField("KIDs", s.jwks.KIDs()).Debug("loaded jwks")
 
 	go s.removeExpiredNonces()
+	go s.removeExpiredBootstrapTokens()
 
 	return s, nil
 }
+
+// validateLifetimes defaults any unset lifetimes and intervals and checks that they
+// are consistent with each other.
+func (s *TlsBootstrapServer) validateLifetimes() error {
+	if s.NonceLifetime == 0 {
+		s.NonceLifetime = NONCE_LIFETIME
+	}
+	if s.TokenLifetime == 0 {
+		s.TokenLifetime = TOKEN_LIFETIME
+	}
+	if s.NonceExpirationCheckInterval == 0 {
+		s.NonceExpirationCheckInterval = NONCE_EXPIRATION_CHECK_INTERVAL
+	}
+	if s.TokenGCInterval == 0 {
+		s.TokenGCInterval = TOKEN_GC_INTERVAL
+	}
+	if s.JwksRefreshInterval == 0 {
+		s.JwksRefreshInterval = JWKS_REFRESH_INTERVAL
+	}
+	if s.ClockSkew == 0 {
+		s.ClockSkew = DEFAULT_CLOCK_SKEW
+	}
+
+	if s.NonceLifetime < 0 {
+		return fmt.Errorf("nonce lifetime must be positive, got %s", s.NonceLifetime)
+	}
+	if s.TokenLifetime < 0 {
+		return fmt.Errorf("token lifetime must be positive, got %s", s.TokenLifetime)
+	}
+	if s.NonceExpirationCheckInterval < 0 {
+		return fmt.Errorf("nonce expiration check interval must be positive, got %s", s.NonceExpirationCheckInterval)
+	}
+	if s.TokenGCInterval < 0 {
+		return fmt.Errorf("token garbage collection interval must be positive, got %s", s.TokenGCInterval)
+	}
+	if s.JwksRefreshInterval < 0 {
+		return fmt.Errorf("JWKS refresh interval must be positive, got %s", s.JwksRefreshInterval)
+	}
+	if s.ClockSkew < 0 {
+		return fmt.Errorf("clock skew must be positive, got %s", s.ClockSkew)
+	}
+	if s.TokenLifetime < s.NonceLifetime {
+		return fmt.Errorf("token lifetime %s must not be shorter than nonce lifetime %s", s.TokenLifetime, s.NonceLifetime)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+type testLifetimes struct {
+	nonce, token, nonceCheckInterval, tokenGCInterval, jwksRefreshInterval, clockSkew time.Duration
+}
+
+func TestValidateLifetimes(t *testing.T) {
+	defaults := testLifetimes{
+		nonce:               NONCE_LIFETIME,
+		token:               TOKEN_LIFETIME,
+		nonceCheckInterval:  NONCE_EXPIRATION_CHECK_INTERVAL,
+		tokenGCInterval:     TOKEN_GC_INTERVAL,
+		jwksRefreshInterval: JWKS_REFRESH_INTERVAL,
+		clockSkew:           DEFAULT_CLOCK_SKEW,
+	}
+	configured := testLifetimes{
+		nonce:               time.Minute,
+		token:               2 * time.Minute,
+		nonceCheckInterval:  time.Second,
+		tokenGCInterval:     time.Hour,
+		jwksRefreshInterval: 30 * time.Minute,
+		clockSkew:           time.Second,
+	}
+	sameLifetimes := defaults
+	sameLifetimes.nonce, sameLifetimes.token = time.Minute, time.Minute
+
+	tests := []struct {
+		name      string
+		lifetimes testLifetimes
+		expected  testLifetimes
+		expectErr bool
+	}{
+		{name: "defaults", lifetimes: testLifetimes{}, expected: defaults},
+		{name: "configured values are kept", lifetimes: configured, expected: configured},
+		{name: "token as long as nonce", lifetimes: testLifetimes{nonce: time.Minute, token: time.Minute}, expected: sameLifetimes},
+		{name: "negative nonce lifetime", lifetimes: testLifetimes{nonce: -time.Second}, expectErr: true},
+		{name: "negative token lifetime", lifetimes: testLifetimes{token: -time.Second}, expectErr: true},
+		{name: "negative nonce expiration check interval", lifetimes: testLifetimes{nonceCheckInterval: -time.Second}, expectErr: true},
+		{name: "negative token gc interval", lifetimes: testLifetimes{tokenGCInterval: -time.Second}, expectErr: true},
+		{name: "negative JWKS refresh interval", lifetimes: testLifetimes{jwksRefreshInterval: -time.Second}, expectErr: true},
+		{name: "negative clock skew", lifetimes: testLifetimes{clockSkew: -time.Second}, expectErr: true},
+		{name: "token shorter than nonce", lifetimes: testLifetimes{nonce: time.Minute, token: 30 * time.Second}, expectErr: true},
+		{name: "token shorter than default nonce lifetime", lifetimes: testLifetimes{token: NONCE_LIFETIME - time.Second}, expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &TlsBootstrapServer{
+				NonceLifetime:                test.lifetimes.nonce,
+				TokenLifetime:                test.lifetimes.token,
+				NonceExpirationCheckInterval: test.lifetimes.nonceCheckInterval,
+				TokenGCInterval:              test.lifetimes.tokenGCInterval,
+				JwksRefreshInterval:          test.lifetimes.jwksRefreshInterval,
+				ClockSkew:                    test.lifetimes.clockSkew,
+			}
+
+			err := s.validateLifetimes()
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := testLifetimes{
+				nonce:               s.NonceLifetime,
+				token:               s.TokenLifetime,
+				nonceCheckInterval:  s.NonceExpirationCheckInterval,
+				tokenGCInterval:     s.TokenGCInterval,
+				jwksRefreshInterval: s.JwksRefreshInterval,
+				clockSkew:           s.ClockSkew,
+			}
+			if got != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, got)
+			}
+		})
+	}
+}
@@ -3,10 +3,13 @@ package server
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	pb "github.com/Azure/aks-tls-bootstrap/pkg/proto"
+	"github.com/Azure/aks-tls-bootstrap/pkg/redact"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
 )
 
 func (s *TlsBootstrapServer) GetToken(ctx context.Context, tokenRequest *pb.TokenRequest) (*pb.TokenResponse, error) {
@@ -15,57 +18,133 @@ func (s *TlsBootstrapServer) GetToken(ctx context.Context, tokenRequest *pb.Toke
 	})
 	requestLog.Infof("received token request")
 
-	attestedData, err := s.validateAttestedData(tokenRequest.AttestedData, s.SignerHostName)
+	auditEvent := auditEventFromContext(ctx)
+
+	tokenClaims, err := tokenClaimsFromContext(ctx)
 	if err != nil {
-		err = fmt.Errorf("failed to validate attested data: %v", err)
+		err = newError(codes.Unauthenticated, REASON_INVALID_TOKEN, "%v", err)
 		requestLog.Error(err)
 		return nil, err
 	}
+	requestLog = requestLog.WithField("oid", tokenClaims.Oid)
+
+	attestedData, err := s.validateAttestedData(tokenRequest.AttestedData, s.SignerHostName)
+	observeStage(STAGE_PKCS7, err)
+	if err != nil {
+		requestLog.WithError(err).Error("failed to validate attested data")
+		return nil, err
+	}
 	requestLog.Infof("validated attested data")
+	auditEvent.VmId = attestedData.VmId
 
-	err = s.validateRequestExistsAndCurrent(attestedData)
+	request, err := s.validateRequestExistsAndCurrent(attestedData)
 	if err != nil {
-		err = fmt.Errorf("failed to match token request nonce to valid existing nonce: %v", err)
-		requestLog.Error(err)
+		observeStage(STAGE_NONCE, err)
+		requestLog.WithError(err).Error("failed to match token request nonce to valid existing nonce")
 		return nil, err
 	}
 	requestLog = requestLog.WithFields(logrus.Fields{
-		"resourceId": s.requests[tokenRequest.Nonce].ResourceId,
+		"resourceId": request.ResourceId,
 		"vmId":       attestedData.VmId,
 	})
 
-	s.requests[tokenRequest.Nonce].VmId = attestedData.VmId
+	err = validateRequestBinding(request, tokenRequest, tokenClaims, attestedData)
+	if err != nil {
+		err = newError(codes.PermissionDenied, REASON_REQUEST_MISMATCH, "token request does not match nonce request: %v", err)
+		observeStage(STAGE_NONCE, err)
+		requestLog.Error(err)
+		return nil, err
+	}
+	requestLog.Info("token request matches caller, resource ID and subscription of nonce request")
+
+	request.VmId = attestedData.VmId
 	requestLog.Info("validating VM ID against ARM")
-	err = s.validateVmId(tokenRequest.Nonce)
+	vm, err := s.validateVmId(ctx, request)
+	observeStage(STAGE_ARM, err)
+	if err != nil {
+		requestLog.WithError(err).Error("failed to validate VM ID")
+		return nil, err
+	}
+	auditEvent.VmName = request.VmName
+
+	err = s.AdmissionPolicy.Admit(attestedData.SubscriptionId, vm)
 	if err != nil {
-		err = fmt.Errorf("failed to validate VM ID: %v", err)
+		requestLog.WithError(err).Error("virtual machine denied by admission policy")
+		err = newError(codes.PermissionDenied, REASON_ADMISSION_DENIED, "virtual machine denied by admission policy: %v", err)
+		observeStage(STAGE_ADMISSION, err)
+		return nil, err
+	}
+	observeStage(STAGE_ADMISSION, nil)
+	request.NodePool = s.AdmissionPolicy.NodePool(vm)
+	auditEvent.NodePool = request.NodePool
+	requestLog.WithField("nodePool", request.NodePool).Info("virtual machine allowed by admission policy")
+
+	// consume the nonce before issuing a token so that concurrent requests
+	// replaying the same nonce cannot both succeed.
+	_, err = s.NonceStore.Consume(attestedData.Nonce)
+	if err == ErrNonceNotFound {
+		err = newError(codes.FailedPrecondition, REASON_NONCE_ALREADY_USED, "nonce %s has already been used", attestedData.Nonce)
+		observeStage(STAGE_NONCE, err)
 		requestLog.Error(err)
 		return nil, err
 	}
+	if err != nil {
+		err = newError(codes.Unavailable, REASON_NONCE_STORE_UNAVAILABLE, "failed to consume nonce %s: %v", attestedData.Nonce, err)
+		observeStage(STAGE_NONCE, err)
+		requestLog.Error(err)
+		return nil, err
+	}
+	observeStage(STAGE_NONCE, nil)
 
-	bootstrapTokenSecret, expiration, err := s.createBootstrapTokenSecret(s.requests[tokenRequest.Nonce].VmName)
+	bootstrapTokenSecret, expiration, err := s.createBootstrapTokenSecret(request)
 	if err != nil {
+		err = newError(codes.Unavailable, REASON_TOKEN_CREATION_FAILED, "%v", err)
+		observeStage(STAGE_SECRET, err)
 		requestLog.Error(err)
 		return nil, err
 	}
+	observeStage(STAGE_SECRET, nil)
+	tokensIssued.Inc()
+	auditEvent.TokenId = strings.SplitN(bootstrapTokenSecret, ".", 2)[0]
 
 	response := &pb.TokenResponse{}
 	response.Token = bootstrapTokenSecret
 	response.Expiration = expiration
 
-	delete(s.requests, tokenRequest.Nonce)
-	requestLog.Info("returning token and flushing nonce from cache")
+	requestLog.WithField("token", redact.BootstrapToken(bootstrapTokenSecret)).Info("returning token and flushed nonce from cache")
 	return response, nil
 }
 
-func (s *TlsBootstrapServer) validateRequestExistsAndCurrent(attestedData *AttestedData) error {
-	nonce, exists := s.requests[attestedData.Nonce]
-	if !exists {
-		return fmt.Errorf("nonce %s not found in cache", attestedData.Nonce)
+func (s *TlsBootstrapServer) validateRequestExistsAndCurrent(attestedData *AttestedData) (*Request, error) {
+	request, err := s.NonceStore.Get(attestedData.Nonce)
+	if err == ErrNonceNotFound {
+		return nil, newError(codes.FailedPrecondition, REASON_NONCE_NOT_FOUND, "nonce %s not found in cache", attestedData.Nonce)
+	}
+	if err != nil {
+		return nil, newError(codes.Unavailable, REASON_NONCE_STORE_UNAVAILABLE, "failed to retrieve nonce %s: %v", attestedData.Nonce, err)
+	}
+
+	if request.Expiration.Before(time.Now()) {
+		return nil, newError(codes.FailedPrecondition, REASON_NONCE_EXPIRED, "nonce %s expired at %s", attestedData.Nonce, request.Expiration.String())
+	}
+
+	return request, nil
+}
+
+// validateRequestBinding ensures a nonce can only be redeemed by the caller which
+// requested it, for the same resource, from a VM in the same subscription.
+func validateRequestBinding(request *Request, tokenRequest *pb.TokenRequest, tokenClaims *AzureADTokenClaims, attestedData *AttestedData) error {
+	if request.CallerOid != tokenClaims.Oid {
+		return fmt.Errorf("caller %s does not match caller %s which requested the nonce", tokenClaims.Oid, request.CallerOid)
+	}
+
+	// ARM resource IDs are case-insensitive
+	if !strings.EqualFold(request.ResourceId, tokenRequest.ResourceId) {
+		return fmt.Errorf("resource ID %s does not match resource ID %s supplied with the nonce request", tokenRequest.ResourceId, request.ResourceId)
 	}
 
-	if nonce.Expiration.Before(time.Now()) {
-		return fmt.Errorf("nonce %s expired at %s", attestedData.Nonce, nonce.Expiration.String())
+	if !strings.EqualFold(request.SubscriptionId, attestedData.SubscriptionId) {
+		return fmt.Errorf("attested subscription ID %s does not match subscription ID %s of resource %s", attestedData.SubscriptionId, request.SubscriptionId, request.ResourceId)
 	}
 
 	return nil
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	pb "github.com/Azure/aks-tls-bootstrap/pkg/proto"
+)
+
+func TestValidateRequestBinding(t *testing.T) {
+	request := &Request{
+		Nonce:          "abc",
+		ResourceId:     testVmResourceId,
+		SubscriptionId: testSubscriptionId,
+		CallerOid:      testCallerOid,
+	}
+
+	tests := []struct {
+		name           string
+		resourceId     string
+		oid            string
+		subscriptionId string
+		valid          bool
+	}{
+		{
+			name:           "matching request",
+			resourceId:     testVmResourceId,
+			oid:            testCallerOid,
+			subscriptionId: testSubscriptionId,
+			valid:          true,
+		},
+		{
+			name:           "resource id in different case",
+			resourceId:     strings.ToUpper(testVmResourceId),
+			oid:            testCallerOid,
+			subscriptionId: strings.ToUpper(testSubscriptionId),
+			valid:          true,
+		},
+		{
+			name:           "different caller",
+			resourceId:     testVmResourceId,
+			oid:            testTenantId,
+			subscriptionId: testSubscriptionId,
+		},
+		{
+			name:           "certificate caller",
+			resourceId:     testVmResourceId,
+			oid:            CERTIFICATE_IDENTITY_PREFIX + testCallerOid,
+			subscriptionId: testSubscriptionId,
+		},
+		{
+			name:           "different resource",
+			resourceId:     testVmssResourceId,
+			oid:            testCallerOid,
+			subscriptionId: testSubscriptionId,
+		},
+		{
+			name:           "attested subscription mismatch",
+			resourceId:     testVmResourceId,
+			oid:            testCallerOid,
+			subscriptionId: testTenantId,
+		},
+		{
+			name:       "attested subscription missing",
+			resourceId: testVmResourceId,
+			oid:        testCallerOid,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateRequestBinding(request,
+				&pb.TokenRequest{ResourceId: test.resourceId, Nonce: request.Nonce},
+				&AzureADTokenClaims{Oid: test.oid},
+				&AttestedData{Nonce: request.Nonce, SubscriptionId: test.subscriptionId})
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected the request binding to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Azure/aks-tls-bootstrap/pkg/redact"
+	"github.com/sirupsen/logrus"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// removeExpiredBootstrapTokens deletes expired bootstrap token secrets created by this
+// server, for overlay clusters where the kube-controller-manager tokencleaner is disabled.
+func (s *TlsBootstrapServer) removeExpiredBootstrapTokens() {
+	interval := s.TokenGCInterval
+
+	s.Log.Infof("starting bootstrap token garbage collector, interval %d second(s)", interval/time.Second)
+	ticker := time.NewTicker(interval)
+
+	for range ticker.C {
+		secrets, err := s.kubeSystemSecretsClient.List(context.Background(), metaV1.ListOptions{
+			LabelSelector: BOOTSTRAP_TOKEN_ISSUER_LABEL + "=" + BOOTSTRAP_TOKEN_ISSUER,
+		})
+		if err != nil {
+			s.Log.WithError(err).Error("failed to list bootstrap tokens")
+			continue
+		}
+
+		now := time.Now()
+		for i := range secrets.Items {
+			secret := &secrets.Items[i]
+			if secret.Type != coreV1.SecretTypeBootstrapToken || !strings.HasPrefix(secret.Name, bootstrapTokenSecretPrefix) {
+				continue
+			}
+
+			expiration, err := time.Parse(time.RFC3339, string(secret.Data["expiration"]))
+			if err == nil && expiration.After(now) {
+				continue
+			}
+
+			// tokens with a missing or unparseable expiration are removed as well.
+			err = s.kubeSystemSecretsClient.Delete(context.Background(), secret.Name, metaV1.DeleteOptions{
+				Preconditions: metaV1.NewUIDPreconditions(string(secret.UID)),
+			})
+			if errors.IsNotFound(err) || errors.IsConflict(err) {
+				continue
+			}
+			if err != nil {
+				s.Log.WithError(err).WithField("secret", secret.Name).Error("failed to delete expired bootstrap token")
+				continue
+			}
+			s.Log.WithFields(logrus.Fields{
+				"secret":     secret.Name,
+				"token":      redact.BootstrapToken(string(secret.Data["token-id"]) + "." + string(secret.Data["token-secret"])),
+				"expiration": string(secret.Data["expiration"]),
+			}).Info("deleted expired bootstrap token")
+		}
+	}
+}
@@ -4,30 +4,68 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/Azure/aks-tls-bootstrap/pkg/azure"
+	"github.com/Azure/aks-tls-bootstrap/pkg/kubeclient"
 	pb "github.com/Azure/aks-tls-bootstrap/pkg/proto"
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/sirupsen/logrus"
 	"k8s.io/client-go/kubernetes"
 	coreV1Types "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
 type TlsBootstrapServer struct {
-	SignerHostName          string
-	AllowedClientIds        []string
-	requests                map[string]*Request
-	JwksUrl                 string
-	Log                     *logrus.Entry
-	k8sClientSet            *kubernetes.Clientset
-	kubeSystemSecretsClient coreV1Types.SecretInterface
-	RootCertPath            string
-	IntermediateCertPath    string
-	rootCertPool            *x509.CertPool
-	intermediateCertPool    *x509.CertPool
-	TenantId                string
-	tlsConfig               *tls.Config
-	httpClient              *http.Client
+	SignerHostName   string
+	ClientAuthMode   string
+	AllowedClientIds []string
+	// AllowedCertificateIdentities are the client certificate identities, as returned
+	// by certificateIdentity, which may call the server in the certificate auth modes.
+	// They are kept apart from the Azure AD object IDs in AllowedClientIds.
+	AllowedCertificateIdentities []string
+	AllowedIdentitiesKind        string
+	AllowedIdentitiesNamespace   string
+	AllowedIdentitiesName        string
+	allowedIdentities            atomic.Value
+	CallerPolicy                 CallerPolicy
+	NonceStore                   NonceStore
+	AuditSink                    AuditSink
+	AuditKubernetesEvents        bool
+	NonceStoreBackend            string
+	NonceStoreNamespace          string
+	JwksUrl                      string
+	jwks                         *keyfunc.JWKS
+	jwksLastRefresh              atomic.Value
+	healthStatus                 atomic.Value
+	Log                          *logrus.Entry
+	KubeClient                   kubeclient.Options
+	k8sClientSet                 kubernetes.Interface
+	kubeSystemSecretsClient      coreV1Types.SecretInterface
+	RootCertPath                 string
+	IntermediateCertPath         string
+	rootCertPool                 *x509.CertPool
+	intermediateCertPool         *x509.CertPool
+	intermediateCertLock         sync.RWMutex
+	TenantId                     string
+	Cloud                        *azure.Environment
+	VMResolver                   VMResolver
+	AdmissionPolicy              AdmissionPolicy
+	AzureConfigPath              string
+	azureConfig                  *azure.KubeletAzureJson
+	azureLock                    sync.RWMutex
+	NonceLifetime                time.Duration
+	TokenLifetime                time.Duration
+	NonceExpirationCheckInterval time.Duration
+	TokenGCInterval              time.Duration
+	JwksRefreshInterval          time.Duration
+	ClockSkew                    time.Duration
+	AllowedSigningAlgorithms     []string
+	Audience                     string
+	tlsConfig                    *tls.Config
+	httpClient                   *http.Client
 	pb.UnimplementedAKSBootstrapTokenRequestServer
 }
 
@@ -69,15 +107,18 @@ type AzureADTokenClaims struct {
 	Ver               string   `json:"ver"`
 	Wids              []string `json:"wids"`
 	XmsTcdt           int64    `json:"xms_tcdt"`
-	jwt.StandardClaims
+	jwt.RegisteredClaims
 }
 
 type Request struct {
-	Nonce      string
-	Expiration time.Time
-	ResourceId string
-	VmId       string
-	VmName     string
+	Nonce          string
+	Expiration     time.Time
+	ResourceId     string
+	SubscriptionId string
+	CallerOid      string
+	VmId           string
+	VmName         string
+	NodePool       string
 }
 
 type AttestedData struct {
@@ -96,11 +137,3 @@ type AttestedData struct {
 	} `json:"timestamp"`
 	VmId string `json:"vmId"`
 }
-
-// TODO: duped from client package.
-type KubeletAzureJson struct {
-	ClientId               string `json:"aadClientId"`
-	ClientSecret           string `json:"aadClientSecret"`
-	TenantId               string `json:"tenantId"`
-	UserAssignedIdentityID string `json:"userAssignedIdentityID"`
-}
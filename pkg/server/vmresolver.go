@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+)
+
+const (
+	VIRTUAL_MACHINE_RESOURCE_TYPE      = "Microsoft.Compute/virtualMachines"
+	VMSS_VIRTUAL_MACHINE_RESOURCE_TYPE = "Microsoft.Compute/virtualMachineScaleSets/virtualMachines"
+)
+
+var (
+	ErrUnsupportedResourceType       = fmt.Errorf("unsupported resource type")
+	ErrNoParentScaleSet              = fmt.Errorf("scale set virtual machine has no parent scale set")
+	ErrVirtualMachineHasNoProperties = fmt.Errorf("virtual machine has no properties")
+)
+
+// VirtualMachine is the subset of an ARM virtual machine needed to validate a token request.
+type VirtualMachine struct {
+	ResourceId     string
+	SubscriptionId string
+	ResourceGroup  string
+	Name           string
+	VmId           string
+	ComputerName   string
+	Tags           map[string]string
+	Zone           string
+	VmssName       string
+}
+
+// VMResolver looks up a virtual machine in ARM by its resource ID.
+type VMResolver interface {
+	GetVirtualMachine(ctx context.Context, resourceId *arm.ResourceID) (*VirtualMachine, error)
+}
+
+// armVMResolver dispatches to the resolver for the resource type of the requested VM.
+type armVMResolver struct {
+	resolvers map[string]VMResolver
+}
+
+func NewARMVMResolver(credential azcore.TokenCredential, options *arm.ClientOptions) VMResolver {
+	return &armVMResolver{
+		resolvers: map[string]VMResolver{
+			strings.ToLower(VIRTUAL_MACHINE_RESOURCE_TYPE): &virtualMachineResolver{
+				credential: credential,
+				options:    options,
+				clients:    make(map[string]*armcompute.VirtualMachinesClient),
+			},
+			strings.ToLower(VMSS_VIRTUAL_MACHINE_RESOURCE_TYPE): &vmssVirtualMachineResolver{
+				credential: credential,
+				options:    options,
+				clients:    make(map[string]*armcompute.VirtualMachineScaleSetVMsClient),
+			},
+		},
+	}
+}
+
+func (r *armVMResolver) GetVirtualMachine(ctx context.Context, resourceId *arm.ResourceID) (*VirtualMachine, error) {
+	resolver, ok := r.resolvers[strings.ToLower(resourceId.ResourceType.String())]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedResourceType, resourceId.ResourceType.String())
+	}
+
+	return resolver.GetVirtualMachine(ctx, resourceId)
+}
+
+type virtualMachineResolver struct {
+	credential azcore.TokenCredential
+	options    *arm.ClientOptions
+	lock       sync.Mutex
+	clients    map[string]*armcompute.VirtualMachinesClient
+}
+
+func (r *virtualMachineResolver) getClient(subscriptionId string) (*armcompute.VirtualMachinesClient, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	client, ok := r.clients[subscriptionId]
+	if !ok {
+		var err error
+		client, err = armcompute.NewVirtualMachinesClient(subscriptionId, r.credential, r.options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create virtual machines client: %v", err)
+		}
+		r.clients[subscriptionId] = client
+	}
+
+	return client, nil
+}
+
+func (r *virtualMachineResolver) GetVirtualMachine(ctx context.Context, resourceId *arm.ResourceID) (*VirtualMachine, error) {
+	client, err := r.getClient(resourceId.SubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.Get(ctx, resourceId.ResourceGroupName, resourceId.Name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve virtual machine %s: %w", resourceId.String(), err)
+	}
+
+	properties := response.Properties
+	if properties == nil {
+		return nil, fmt.Errorf("%s: %w", resourceId.String(), ErrVirtualMachineHasNoProperties)
+	}
+
+	vm := &VirtualMachine{
+		ResourceId:     resourceId.String(),
+		SubscriptionId: resourceId.SubscriptionID,
+		ResourceGroup:  resourceId.ResourceGroupName,
+		Name:           stringValue(response.Name),
+		VmId:           stringValue(properties.VMID),
+		Tags:           tagValues(response.Tags),
+		Zone:           firstValue(response.Zones),
+	}
+	if properties.OSProfile != nil {
+		vm.ComputerName = stringValue(properties.OSProfile.ComputerName)
+	}
+	// VMs in a flexible orchestration scale set are standalone VMs which reference their scale set
+	if properties.VirtualMachineScaleSet != nil && properties.VirtualMachineScaleSet.ID != nil {
+		vmssId, err := arm.ParseResourceID(*properties.VirtualMachineScaleSet.ID)
+		if err == nil {
+			vm.VmssName = vmssId.Name
+		}
+	}
+
+	return vm, nil
+}
+
+type vmssVirtualMachineResolver struct {
+	credential azcore.TokenCredential
+	options    *arm.ClientOptions
+	lock       sync.Mutex
+	clients    map[string]*armcompute.VirtualMachineScaleSetVMsClient
+}
+
+func (r *vmssVirtualMachineResolver) getClient(subscriptionId string) (*armcompute.VirtualMachineScaleSetVMsClient, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	client, ok := r.clients[subscriptionId]
+	if !ok {
+		var err error
+		client, err = armcompute.NewVirtualMachineScaleSetVMsClient(subscriptionId, r.credential, r.options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create scale set virtual machines client: %v", err)
+		}
+		r.clients[subscriptionId] = client
+	}
+
+	return client, nil
+}
+
+func (r *vmssVirtualMachineResolver) GetVirtualMachine(ctx context.Context, resourceId *arm.ResourceID) (*VirtualMachine, error) {
+	if resourceId.Parent == nil {
+		return nil, fmt.Errorf("%s: %w", resourceId.String(), ErrNoParentScaleSet)
+	}
+
+	client, err := r.getClient(resourceId.SubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.Get(ctx, resourceId.ResourceGroupName, resourceId.Parent.Name, resourceId.Name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve scale set virtual machine %s: %w", resourceId.String(), err)
+	}
+
+	properties := response.Properties
+	if properties == nil {
+		return nil, fmt.Errorf("%s: %w", resourceId.String(), ErrVirtualMachineHasNoProperties)
+	}
+
+	vm := &VirtualMachine{
+		ResourceId:     resourceId.String(),
+		SubscriptionId: resourceId.SubscriptionID,
+		ResourceGroup:  resourceId.ResourceGroupName,
+		Name:           stringValue(response.Name),
+		VmId:           stringValue(properties.VMID),
+		Tags:           tagValues(response.Tags),
+		Zone:           firstValue(response.Zones),
+		VmssName:       resourceId.Parent.Name,
+	}
+	if properties.OSProfile != nil {
+		vm.ComputerName = stringValue(properties.OSProfile.ComputerName)
+	}
+
+	return vm, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func firstValue(values []*string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return stringValue(values[0])
+}
+
+func tagValues(tags map[string]*string) map[string]string {
+	result := make(map[string]string, len(tags))
+	for key, value := range tags {
+		result[key] = stringValue(value)
+	}
+	return result
+}
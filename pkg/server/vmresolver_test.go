@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// fakeVMResolver returns virtual machines from a map keyed by lower-case resource ID.
+type fakeVMResolver struct {
+	VirtualMachines map[string]*VirtualMachine
+	Err             error
+}
+
+func (f *fakeVMResolver) GetVirtualMachine(ctx context.Context, resourceId *arm.ResourceID) (*VirtualMachine, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	vm, ok := f.VirtualMachines[strings.ToLower(resourceId.String())]
+	if !ok {
+		// mimic the 404 returned by ARM so that callers map it the same way
+		response := &http.Response{
+			StatusCode: http.StatusNotFound,
+			Status:     http.StatusText(http.StatusNotFound),
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{"error":{"code":"ResourceNotFound"}}`)),
+			Request: &http.Request{
+				Method: http.MethodGet,
+				URL:    &url.URL{Scheme: "https", Host: "management.azure.com", Path: resourceId.String()},
+			},
+		}
+		return nil, fmt.Errorf("failed to retrieve virtual machine %s: %w", resourceId.String(), runtime.NewResponseError(response))
+	}
+
+	return vm, nil
+}